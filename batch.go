@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charleshuang3/subtrans/pkg/sub"
+)
+
+// defaultOutputTemplate names the outputs of a batch run when -o is not given.
+const defaultOutputTemplate = "{dir}/{name}.{lang}.{ext}"
+
+// subtitleExts are the extensions of the subtitle files found in input directories.
+var subtitleExts = []string{".srt", ".vtt", ".ass", ".ssa", ".stl", ".ttml"}
+
+// sourceFile is a subtitle file found for a batch run.
+type sourceFile struct {
+	path string
+	// root is the directory the file was found in, {reldir} is the directory of the file relative to it
+	root string
+}
+
+// jobOutput is the output of one target language.
+type jobOutput struct {
+	lang string
+	path string
+}
+
+// job is one input translated to an output per target language.
+type job struct {
+	input   string
+	outputs []jobOutput
+}
+
+type jobStatus int
+
+const (
+	statusNotStarted jobStatus = iota
+	statusTranslated
+	statusUpToDate
+	statusFailed
+)
+
+type jobResult struct {
+	job
+	status jobStatus
+	err    error
+}
+
+// isBatchInput reports whether input names several files: a directory or a glob pattern.
+func isBatchInput(input string) bool {
+	if strings.ContainsAny(input, "*?[") {
+		return true
+	}
+	info, err := os.Stat(input)
+	return err == nil && info.IsDir()
+}
+
+// discoverInputs returns the subtitle files of input, a directory searched recursively or a glob pattern whose
+// matching directories are searched recursively, sorted by path.
+func discoverInputs(input string) ([]sourceFile, error) {
+	matches := []string{input}
+	if strings.ContainsAny(input, "*?[") {
+		var err error
+		matches, err = filepath.Glob(input)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %s: %w", input, err)
+		}
+	}
+
+	seen := map[string]bool{}
+	var files []sourceFile
+	add := func(path, root string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, sourceFile{path: path, root: root})
+		}
+	}
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			// a file matched by the pattern was asked for, whatever its extension
+			add(match, filepath.Dir(match))
+			continue
+		}
+		err = filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && slices.Contains(subtitleExts, strings.ToLower(filepath.Ext(path))) {
+				add(path, match)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no subtitle files found in %s", input)
+	}
+	slices.SortFunc(files, func(a, b sourceFile) int { return strings.Compare(a.path, b.path) })
+	return files, nil
+}
+
+// expandOutput names the output of f from template: {dir} is the directory of the input, {reldir} that directory
+// relative to the searched directory, {name} the file name without extension, {ext} the extension without dot and
+// {lang} the target language.
+func expandOutput(template string, f sourceFile, lang string) string {
+	dir := filepath.Dir(f.path)
+	reldir, err := filepath.Rel(f.root, dir)
+	if err != nil {
+		reldir = "."
+	}
+	base := filepath.Base(f.path)
+	ext := filepath.Ext(base)
+	r := strings.NewReplacer(
+		"{dir}", dir,
+		"{reldir}", reldir,
+		"{name}", strings.TrimSuffix(base, ext),
+		"{ext}", strings.TrimPrefix(ext, "."),
+		// a language is no path
+		"{lang}", strings.ReplaceAll(lang, string(filepath.Separator), "-"),
+	)
+	return filepath.Clean(r.Replace(template))
+}
+
+// planBatch pairs every subtitle file of input with its output of every language. Files that are the output of
+// another input, such as the translations of an earlier run next to their sources, are no inputs.
+func planBatch(input, template string, langs []string) ([]job, error) {
+	files, err := discoverInputs(input)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]job, len(files))
+	outputs := map[string]bool{}
+	for i, f := range files {
+		jobs[i].input = f.path
+		for _, lang := range langs {
+			path := expandOutput(template, f, lang)
+			jobs[i].outputs = append(jobs[i].outputs, jobOutput{lang: lang, path: path})
+			outputs[path] = true
+		}
+	}
+	return slices.DeleteFunc(jobs, func(j job) bool {
+		return outputs[j.input] && !slices.ContainsFunc(j.outputs, func(o jobOutput) bool { return o.path == j.input })
+	}), nil
+}
+
+// upToDate reports whether output exists, is not older than input and has no unfinished checkpoint.
+func upToDate(input, output string) bool {
+	out, err := os.Stat(output)
+	if err != nil {
+		return false
+	}
+	in, err := os.Stat(input)
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(sub.CheckpointPath(output)); err == nil {
+		return false
+	}
+	return !out.ModTime().Before(in.ModTime())
+}
+
+// runBatch translates the jobs one after another to the outputs that are not up to date. A failed file does not stop
+// the others, a cancelled context stops the run before the next file.
+func runBatch(ctx context.Context, jobs []job, force bool, translate func(ctx context.Context, input string, outputs []jobOutput) error) []jobResult {
+	results := make([]jobResult, len(jobs))
+jobs:
+	for i, j := range jobs {
+		results[i].job = j
+		if ctx.Err() != nil {
+			continue
+		}
+		var stale []jobOutput
+		for _, o := range j.outputs {
+			if o.path == j.input {
+				results[i].status = statusFailed
+				results[i].err = errors.New("output template names the input file")
+				continue jobs
+			}
+			if force || !upToDate(j.input, o.path) {
+				stale = append(stale, o)
+			}
+		}
+		if len(stale) == 0 {
+			log.Printf("[%d/%d] %s is up to date", i+1, len(jobs), j.input)
+			results[i].status = statusUpToDate
+			continue
+		}
+
+		for _, o := range stale {
+			log.Printf("[%d/%d] Translating %s to %s", i+1, len(jobs), j.input, o.path)
+			if err := os.MkdirAll(filepath.Dir(o.path), 0755); err != nil {
+				results[i].status = statusFailed
+				results[i].err = err
+				continue jobs
+			}
+		}
+		if err := translate(ctx, j.input, stale); err != nil {
+			log.Printf("[%d/%d] Error translating %s: %v", i+1, len(jobs), j.input, err)
+			results[i].status = statusFailed
+			results[i].err = err
+			continue
+		}
+		results[i].status = statusTranslated
+	}
+	return results
+}
+
+func countFailed(results []jobResult) int {
+	n := 0
+	for _, r := range results {
+		if r.status == statusFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// batchSummary counts the results and lists every failed file with the position its translation stopped at.
+func batchSummary(results []jobResult) string {
+	counts := map[jobStatus]int{}
+	for _, r := range results {
+		counts[r.status]++
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Batch: %d files, %d translated, %d up to date, %d failed",
+		len(results), counts[statusTranslated], counts[statusUpToDate], counts[statusFailed])
+	if n := counts[statusNotStarted]; n > 0 {
+		fmt.Fprintf(&b, ", %d not started", n)
+	}
+	for _, r := range results {
+		if r.status != statusFailed {
+			continue
+		}
+		fmt.Fprintf(&b, "\n  %s: %v", r.input, r.err)
+		var translationErr *sub.TranslationError
+		if errors.As(r.err, &translationErr) {
+			item, line, seg := translationErr.ResumeIndex()
+			fmt.Fprintf(&b, " (stopped at %d,%d,%d, resume with -resume)", item, line, seg)
+		}
+	}
+	return b.String()
+}
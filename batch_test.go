@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, names ...string) {
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n"), 0644))
+	}
+}
+
+func TestDiscoverInputs(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "s01/e02.srt", "s01/e01.SRT", "s02/e01.ass", "s02/notes.txt", "e00.vtt")
+
+	files, err := discoverInputs(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []sourceFile{
+		{path: filepath.Join(dir, "e00.vtt"), root: dir},
+		{path: filepath.Join(dir, "s01/e01.SRT"), root: dir},
+		{path: filepath.Join(dir, "s01/e02.srt"), root: dir},
+		{path: filepath.Join(dir, "s02/e01.ass"), root: dir},
+	}, files)
+
+	files, err = discoverInputs(filepath.Join(dir, "s0*"))
+	require.NoError(t, err)
+	assert.Len(t, files, 3)
+	assert.Equal(t, sourceFile{path: filepath.Join(dir, "s01/e01.SRT"), root: filepath.Join(dir, "s01")}, files[0])
+
+	files, err = discoverInputs(filepath.Join(dir, "s02", "*.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, []sourceFile{{path: filepath.Join(dir, "s02/notes.txt"), root: filepath.Join(dir, "s02")}}, files)
+
+	_, err = discoverInputs(filepath.Join(dir, "*.mkv"))
+	assert.ErrorContains(t, err, "no subtitle files found")
+}
+
+func TestExpandOutput(t *testing.T) {
+	f := sourceFile{path: "/in/season1/ep01.en.srt", root: "/in"}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{defaultOutputTemplate, "/in/season1/ep01.en.German.srt"},
+		{"/out/{reldir}/{name}.{ext}", "/out/season1/ep01.en.srt"},
+		{"{dir}/../translated/{name}_{lang}.ass", "/in/translated/ep01.en_German.ass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			assert.Equal(t, tt.want, expandOutput(tt.template, f, "German"))
+		})
+	}
+	assert.Equal(t, "/in/season1/ep01.en.zh-Hans.srt", expandOutput(defaultOutputTemplate, f, "zh/Hans"))
+}
+
+func TestPlanBatchSkipsOutputs(t *testing.T) {
+	dir := t.TempDir()
+	// the translation of an earlier run sits next to its source
+	writeFiles(t, dir, "ep01.srt", "ep01.German.srt", "ep02.srt")
+
+	jobs, err := planBatch(dir, defaultOutputTemplate, []string{"German", "French"})
+	require.NoError(t, err)
+	assert.Equal(t, []job{
+		{input: filepath.Join(dir, "ep01.srt"), outputs: []jobOutput{
+			{lang: "German", path: filepath.Join(dir, "ep01.German.srt")},
+			{lang: "French", path: filepath.Join(dir, "ep01.French.srt")},
+		}},
+		{input: filepath.Join(dir, "ep02.srt"), outputs: []jobOutput{
+			{lang: "German", path: filepath.Join(dir, "ep02.German.srt")},
+			{lang: "French", path: filepath.Join(dir, "ep02.French.srt")},
+		}},
+	}, jobs)
+}
+
+func TestUpToDate(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "in.srt", "out.srt")
+	input, output := filepath.Join(dir, "in.srt"), filepath.Join(dir, "out.srt")
+	old := time.Now().Add(-time.Hour)
+
+	require.NoError(t, os.Chtimes(input, old, old))
+	assert.True(t, upToDate(input, output))
+
+	require.NoError(t, os.WriteFile(sub.CheckpointPath(output), []byte("{}"), 0644))
+	assert.False(t, upToDate(input, output), "unfinished checkpoint")
+	require.NoError(t, os.Remove(sub.CheckpointPath(output)))
+
+	require.NoError(t, os.Chtimes(output, old.Add(-time.Hour), old.Add(-time.Hour)))
+	assert.False(t, upToDate(input, output), "input changed after the output")
+
+	assert.False(t, upToDate(input, filepath.Join(dir, "missing.srt")))
+}
+
+func TestRunBatch(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.srt", "b.srt", "c.srt", "c.de.srt", "e.srt", "e.de.srt")
+	output := func(name, lang string) jobOutput {
+		return jobOutput{lang: lang, path: filepath.Join(dir, name)}
+	}
+	jobs := []job{
+		{input: filepath.Join(dir, "a.srt"), outputs: []jobOutput{output("out/a.de.srt", "de")}},
+		{input: filepath.Join(dir, "b.srt"), outputs: []jobOutput{output("out/b.de.srt", "de")}},
+		{input: filepath.Join(dir, "c.srt"), outputs: []jobOutput{output("c.de.srt", "de")}},
+		{input: filepath.Join(dir, "d.srt"), outputs: []jobOutput{output("d.srt", "de")}},
+		{input: filepath.Join(dir, "e.srt"), outputs: []jobOutput{output("e.de.srt", "de"), output("e.fr.srt", "fr")}},
+	}
+	failure := &sub.TranslationError{BatchNumber: 2, Err: errors.New("quota exceeded")}
+
+	var translated []string
+	var translatedOutputs [][]jobOutput
+	translate := func(ctx context.Context, input string, outputs []jobOutput) error {
+		translated = append(translated, input)
+		translatedOutputs = append(translatedOutputs, outputs)
+		if input == jobs[1].input {
+			return failure
+		}
+		for _, o := range outputs {
+			if err := os.WriteFile(o.path, nil, 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	results := runBatch(t.Context(), jobs, false, translate)
+	// the failure of b does not stop the run, c is up to date
+	assert.Equal(t, []string{jobs[0].input, jobs[1].input, jobs[4].input}, translated)
+	assert.FileExists(t, jobs[0].outputs[0].path)
+	// only the missing language of e is translated
+	assert.Equal(t, []jobOutput{output("e.fr.srt", "fr")}, translatedOutputs[2])
+	assert.Equal(t, []jobStatus{statusTranslated, statusFailed, statusUpToDate, statusFailed, statusTranslated},
+		[]jobStatus{results[0].status, results[1].status, results[2].status, results[3].status, results[4].status})
+	assert.Equal(t, 2, countFailed(results))
+
+	summary := batchSummary(results)
+	assert.Contains(t, summary, "Batch: 5 files, 2 translated, 1 up to date, 2 failed")
+	assert.Contains(t, summary, jobs[1].input+": batch 2 failed: quota exceeded")
+	assert.Contains(t, summary, "resume with -resume")
+	assert.Contains(t, summary, jobs[3].input+": output template names the input file")
+
+	translated = nil
+	runBatch(t.Context(), jobs[2:3], true, translate)
+	assert.Equal(t, []string{jobs[2].input}, translated, "forced")
+}
+
+func TestRunBatchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	jobs := []job{
+		{input: "a.srt", outputs: []jobOutput{{lang: "de", path: "a.de.srt"}}},
+		{input: "b.srt", outputs: []jobOutput{{lang: "de", path: "b.de.srt"}}},
+	}
+	results := runBatch(ctx, jobs, true, func(ctx context.Context, input string, outputs []jobOutput) error {
+		cancel()
+		return ctx.Err()
+	})
+	assert.Equal(t, statusFailed, results[0].status)
+	assert.Equal(t, statusNotStarted, results[1].status)
+	assert.Contains(t, batchSummary(results), ", 1 not started")
+}
@@ -1,12 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/charleshuang3/subtrans/pkg/cache"
 	"github.com/charleshuang3/subtrans/pkg/config"
 	"github.com/charleshuang3/subtrans/pkg/sub"
 	"github.com/charleshuang3/subtrans/pkg/translator"
@@ -35,21 +48,82 @@ func parseFromIndex(fromIndex string) (int, int, int, error) {
 }
 
 func main() {
-	inputFile := flag.String("i", "", "input file path (required)")
-	outputFile := flag.String("o", "", "output file path (required)")
-	targetLang := flag.String("target-lang", "", "target language (optional)")
+	inputFile := flag.String("i", "", "input file path, - for stdin, or a directory or glob pattern of files to translate (required)")
+	outputFile := flag.String("o", "", "output file path, - for stdout, or template, e.g. {dir}/{name}.{lang}.{ext}, required for a single input (optional)")
+	inFormat := flag.String("in-format", "", "format of the input: srt, vtt, ass, ssa, stl or ttml, empty uses the extension, required for stdin (optional)")
+	outFormat := flag.String("out-format", "", "format of the output, empty uses the extension, or the input format for stdout (optional)")
+	targetLang := flag.String("target-lang", "", "target language, or a comma-separated list of languages each written to its own output (optional)")
 	configPath := flag.String("c", "", "config file path (optional)")
 	fromIndex := flag.String("from", "", "resume from index (item,line,seg)")
+	resume := flag.Bool("resume", false, "resume from the checkpoint file next to the output (optional)")
 	promptKey := flag.String("prompt", "default", "prompt key from config (optional)")
 	llmProvider := flag.String("llm", "default", "LLM provider to use (optional)")
 	dryRun := flag.Bool("dry-run", false, "dry run without making API calls (optional)")
+	timeout := flag.Duration("timeout", 0, "timeout for a single request, e.g. 2m, 0 uses the config (optional)")
+	retries := flag.Int("retries", 0, "attempts per request including the first one, 0 uses the config (optional)")
+	contextBefore := flag.Int("context-before", -1, "preceding lines sent with each batch as context, -1 uses the config (optional)")
+	contextAfter := flag.Int("context-after", -1, "following lines sent with each batch as context, -1 uses the config (optional)")
+	bilingual := flag.Bool("bilingual", false, "write the source text together with the translation (optional)")
+	bilingualOrder := flag.String("bilingual-order", "", "source_first or translation_first, empty uses the config (optional)")
+	unit := flag.String("unit", "", "segment, line, cue or sentence, the part of a subtitle translated as one text, empty uses the config (optional)")
+	concurrency := flag.Int("concurrency", 0, "number of batches translated in parallel, 0 uses the LLM provider config (optional)")
+	glossaryPath := flag.String("glossary", "", "glossary file with terms added to the glossary of the config (optional)")
+	noCache := flag.Bool("no-cache", false, "neither read nor write the translation cache (optional)")
+	clearCache := flag.Bool("clear-cache", false, "clear the translation cache, without -i it exits afterwards (optional)")
+	reportPath := flag.String("report", "", "write a JSON run report with the timing, tokens and cost of every request (optional)")
+	multiLangPrompt := flag.Bool("multi-lang-prompt", false, "translate every batch to all target languages in one request (optional)")
+	includeStyles := flag.String("include-styles", "", "comma-separated ASS/SSA styles whose events are translated, empty uses the config (optional)")
+	excludeStyles := flag.String("exclude-styles", "", "comma-separated ASS/SSA styles whose events are kept untranslated, empty uses the config (optional)")
+	includeActors := flag.String("include-actors", "", "comma-separated ASS/SSA actors whose events are translated, empty uses the config (optional)")
+	excludeActors := flag.String("exclude-actors", "", "comma-separated ASS/SSA actors whose events are kept untranslated, empty uses the config (optional)")
+	force := flag.Bool("force", false, "translate the files of a directory or glob even if their output is up to date (optional)")
 	flag.Parse()
 
+	if *inputFile == "" && *clearCache {
+		clearTranslationCache(*configPath)
+		return
+	}
 	if *inputFile == "" {
 		log.Fatalf("Error: -i (input file) is required")
 	}
+	batch := isBatchInput(*inputFile)
 	if *outputFile == "" {
-		log.Fatalf("Error: -o (output file) is required")
+		if !batch {
+			log.Fatalf("Error: -o (output file) is required")
+		}
+		*outputFile = defaultOutputTemplate
+	}
+	if *fromIndex != "" && *resume {
+		log.Fatalf("Error: -from and -resume cannot be used together")
+	}
+	if *fromIndex != "" && batch {
+		log.Fatalf("Error: -from cannot be used with a directory or glob input, use -resume")
+	}
+	// stdin or stdout, the output is written once the translation is complete
+	stream := *inputFile == "-" || *outputFile == "-"
+	if stream && batch {
+		log.Fatalf("Error: -o - cannot be used with a directory or glob input")
+	}
+	if stream && (*fromIndex != "" || *resume) {
+		log.Fatalf("Error: -from and -resume need input and output files")
+	}
+	if *inputFile == "-" && strings.Contains(*outputFile, "{") {
+		log.Fatalf("Error: -o cannot be a template when reading from stdin")
+	}
+	var inputFormat, outputFormat sub.Format
+	if *inFormat != "" {
+		var err error
+		if inputFormat, err = sub.ParseFormat(*inFormat); err != nil {
+			log.Fatalf("Error: -in-format: %v", err)
+		}
+	} else if *inputFile == "-" {
+		log.Fatalf("Error: -in-format is required when reading from stdin")
+	}
+	if *outFormat != "" {
+		var err error
+		if outputFormat, err = sub.ParseFormat(*outFormat); err != nil {
+			log.Fatalf("Error: -out-format: %v", err)
+		}
 	}
 
 	log.Printf("input file: %s", *inputFile)
@@ -65,11 +139,73 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error reading config file: %v", err)
 	}
-	if *targetLang != "" {
-		// overwrite target language
-		cfg.TargetLang = *targetLang
+	if *glossaryPath != "" {
+		glossary, err := config.ReadGlossary(*glossaryPath)
+		if err != nil {
+			log.Fatalf("Error reading glossary file: %v", err)
+		}
+		cfg.Glossary.Merge(glossary)
+	}
+	if langs := config.ParseLangs(*targetLang); len(langs) > 0 {
+		// overwrite target languages
+		cfg.TargetLangs = langs
+		cfg.TargetLang = langs[0]
+	}
+	if *multiLangPrompt {
+		// overwrite multi-language requests
+		cfg.MultiLangPrompt = true
+	}
+	if *timeout > 0 {
+		// overwrite request timeout
+		cfg.Retry.Timeout = *timeout
+	}
+	if *retries > 0 {
+		// overwrite request attempts
+		cfg.Retry.MaxAttempts = *retries
+	}
+	if *bilingual {
+		// overwrite bilingual output
+		cfg.Bilingual.Enabled = true
+	}
+	if *bilingualOrder != "" {
+		if *bilingualOrder != config.SourceFirst && *bilingualOrder != config.TranslationFirst {
+			log.Fatalf("Error: -bilingual-order must be %s or %s", config.SourceFirst, config.TranslationFirst)
+		}
+		cfg.Bilingual.Order = *bilingualOrder
+	}
+	if *unit != "" {
+		if *unit != config.UnitSegment && *unit != config.UnitLine && *unit != config.UnitCue && *unit != config.UnitSentence {
+			log.Fatalf("Error: -unit must be %s, %s, %s or %s", config.UnitSegment, config.UnitLine, config.UnitCue, config.UnitSentence)
+		}
+		cfg.Unit = *unit
+	}
+	// overwrite ASS/SSA event filters
+	if styles := config.ParseList(*includeStyles); len(styles) > 0 {
+		cfg.SSA.IncludeStyles = styles
+	}
+	if styles := config.ParseList(*excludeStyles); len(styles) > 0 {
+		cfg.SSA.ExcludeStyles = styles
+	}
+	if actors := config.ParseList(*includeActors); len(actors) > 0 {
+		cfg.SSA.IncludeActors = actors
+	}
+	if actors := config.ParseList(*excludeActors); len(actors) > 0 {
+		cfg.SSA.ExcludeActors = actors
+	}
+	langs := []string(cfg.TargetLangs)
+	if len(langs) == 0 {
+		langs = []string{cfg.TargetLang}
+	}
+	if len(langs) > 1 && !strings.Contains(*outputFile, "{lang}") {
+		log.Fatalf("Error: -o must contain {lang} with several target languages")
 	}
-	log.Printf("target lang: %s", cfg.TargetLang)
+	if len(langs) > 1 && len(cfg.Glossary.Terms) > 0 {
+		log.Fatalf("Error: glossary terms have a translation in one language, they cannot be used with several target languages")
+	}
+	if len(langs) > 1 && *fromIndex != "" {
+		log.Fatalf("Error: -from cannot be used with several target languages, use -resume")
+	}
+	log.Printf("target lang: %s", strings.Join(langs, ", "))
 	log.Printf("LLM provider: %s", *llmProvider)
 
 	var fromItem, fromLine, fromSeg int
@@ -82,20 +218,303 @@ func main() {
 		log.Printf("resuming from index: %d,%d,%d", fromItem, fromLine, fromSeg)
 	}
 
-	translator, err := translator.NewLLMTranslator(cfg, *promptKey, *llmProvider, *dryRun)
-	if err != nil {
-		log.Fatalf("Error creating translator: %v", err)
+	recorder := translator.NewRecorder(cfg.Pricing)
+	// translators of the target languages, they share one request per batch with a multi-language prompt
+	llmTranslators := make(map[string]sub.Translator, len(langs))
+	var fallbackTranslators []*translator.FallbackTranslator
+	if cfg.MultiLangPrompt && len(langs) > 1 {
+		multi, err := translator.NewMultiLangTranslator(cfg, *promptKey, *llmProvider, langs, *dryRun, recorder)
+		if err != nil {
+			log.Fatalf("Error creating translator: %v", err)
+		}
+		for _, lang := range langs {
+			llmTranslators[lang] = multi.Lang(lang)
+		}
+		if f, ok := multi.Translator().(*translator.FallbackTranslator); ok {
+			fallbackTranslators = append(fallbackTranslators, f)
+		}
+	} else {
+		for _, lang := range langs {
+			langCfg := *cfg
+			langCfg.TargetLang = lang
+			llmTranslator, err := translator.NewLLMTranslator(&langCfg, *promptKey, *llmProvider, *dryRun, recorder)
+			if err != nil {
+				log.Fatalf("Error creating translator: %v", err)
+			}
+			llmTranslators[lang] = llmTranslator
+			if f, ok := llmTranslator.(*translator.FallbackTranslator); ok {
+				fallbackTranslators = append(fallbackTranslators, f)
+			}
+		}
 	}
 
 	log.Printf("dry run: %t", *dryRun)
+	log.Printf("multi-language prompt: %t", cfg.MultiLangPrompt && len(langs) > 1)
 
-	if *fromIndex != "" {
-		err = sub.TranslateFileFromIndex(*inputFile, *outputFile, translator, fromItem, fromLine, fromSeg)
-	} else {
-		err = sub.TranslateFile(*inputFile, *outputFile, translator)
+	provider, err := cfg.ResolveLLM(*llmProvider)
+	if err != nil {
+		log.Fatalf("Error getting LLM provider: %v", err)
 	}
+	promptTmpl, err := translator.PromptTemplate(cfg, *promptKey)
 	if err != nil {
+		log.Fatalf("Error getting prompt template: %v", err)
+	}
+	promptHash := sha256.Sum256([]byte(promptTmpl))
+
+	if *clearCache {
+		clearTranslationCache(confPath)
+	}
+	var cacheTranslators []*translator.CacheTranslator
+	if !cfg.Cache.Disabled && !*noCache && !*dryRun {
+		cacheProviders, err := translator.CacheProviders(cfg, *llmProvider)
+		if err != nil {
+			log.Fatalf("Error getting LLM provider: %v", err)
+		}
+		// another run holding the cache must not stop this one
+		if c, err := openCache(cfg); err != nil {
+			log.Printf("Warning: translating without the translation cache: %v", err)
+		} else {
+			defer c.Close()
+			for _, lang := range langs {
+				cacheTranslator := translator.NewCacheTranslator(llmTranslators[lang], c, translator.CacheScope{
+					Providers:  cacheProviders,
+					Prompt:     hex.EncodeToString(promptHash[:]),
+					TargetLang: lang,
+					Glossary:   glossaryHash(cfg.Glossary),
+					Context:    cfg.Cache.IncludeContext,
+				}, cfg.Glossary)
+				llmTranslators[lang] = cacheTranslator
+				cacheTranslators = append(cacheTranslators, cacheTranslator)
+			}
+		}
+	}
+
+	opts := sub.Options{
+		Concurrency: provider.Concurrency,
+		Checkpoint:  !*dryRun && !stream,
+		Resume:      *resume,
+		Settings: sub.RunSettings{
+			Provider:   *llmProvider,
+			Model:      provider.Model,
+			Prompt:     hex.EncodeToString(promptHash[:]),
+			TargetLang: cfg.TargetLang,
+			Unit:       cfg.Unit,
+		},
+		Unit: sub.Unit(cfg.Unit),
+		Sentence: sub.Sentence{
+			MaxGap:  cfg.Sentence.MaxGap,
+			MaxCues: cfg.Sentence.MaxCues,
+		},
+		InputFormat:  inputFormat,
+		OutputFormat: outputFormat,
+		SSA: sub.SSA{
+			IncludeStyles: cfg.SSA.IncludeStyles,
+			ExcludeStyles: cfg.SSA.ExcludeStyles,
+			IncludeActors: cfg.SSA.IncludeActors,
+			ExcludeActors: cfg.SSA.ExcludeActors,
+		},
+	}
+	if *concurrency > 0 {
+		// overwrite provider concurrency
+		opts.Concurrency = *concurrency
+	}
+	log.Printf("concurrency: %d", opts.Concurrency)
+
+	opts.ContextBefore = cfg.ContextBefore
+	if *contextBefore >= 0 {
+		opts.ContextBefore = *contextBefore
+	}
+	opts.ContextAfter = cfg.ContextAfter
+	if *contextAfter >= 0 {
+		opts.ContextAfter = *contextAfter
+	}
+	log.Printf("context lines: %d before, %d after", opts.ContextBefore, opts.ContextAfter)
+
+	opts.Bilingual = sub.Bilingual{
+		Enabled:          cfg.Bilingual.Enabled,
+		TranslationFirst: cfg.Bilingual.Order == config.TranslationFirst,
+		Separator:        cfg.Bilingual.Separator,
+	}
+	log.Printf("bilingual: %t", opts.Bilingual.Enabled)
+	log.Printf("unit: %s", opts.Unit)
+
+	// The first SIGINT/SIGTERM stops dispatching and writes the partial output, a second one kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopInterruptLog := context.AfterFunc(ctx, func() {
+		log.Printf("Interrupted, writing partial translation (press Ctrl-C again to quit immediately)")
+		stop()
+	})
+	defer stopInterruptLog()
+
+	translate := func(ctx context.Context, input string, outputs []jobOutput) error {
+		if stream {
+			return translateStream(ctx, input, outputs[0].path, llmTranslators[outputs[0].lang], opts)
+		}
+		if *fromIndex != "" {
+			return sub.TranslateFileFromIndex(ctx, input, outputs[0].path, llmTranslators[outputs[0].lang], fromItem, fromLine, fromSeg, opts)
+		}
+		targets := make([]sub.Target, len(outputs))
+		for i, output := range outputs {
+			targets[i] = sub.Target{Lang: output.lang, OutputPath: output.path, Translator: llmTranslators[output.lang]}
+		}
+		return sub.TranslateFileTargets(ctx, input, targets, opts)
+	}
+
+	start := time.Now()
+	var results []jobResult
+	if batch {
+		var jobs []job
+		jobs, err = planBatch(*inputFile, *outputFile, langs)
+		if err != nil {
+			log.Fatalf("Error finding input files: %v", err)
+		}
+		log.Printf("input files: %d", len(jobs))
+		results = runBatch(ctx, jobs, *force, translate)
+	} else {
+		outputs := make([]jobOutput, len(langs))
+		for i, lang := range langs {
+			outputs[i] = jobOutput{lang: lang, path: *outputFile}
+			if strings.Contains(*outputFile, "{") {
+				outputs[i].path = expandOutput(*outputFile, sourceFile{path: *inputFile, root: filepath.Dir(*inputFile)}, lang)
+				log.Printf("output file: %s", outputs[i].path)
+			}
+		}
+		err = translate(ctx, *inputFile, outputs)
+	}
+	for _, fallbackTranslator := range fallbackTranslators {
+		log.Print(fallbackTranslator.Summary())
+	}
+	var hits, misses int64
+	for _, cacheTranslator := range cacheTranslators {
+		h, m := cacheTranslator.Stats()
+		hits, misses = hits+h, misses+m
+	}
+	if total := hits + misses; total > 0 {
+		log.Printf("Cache: %d hits, %d misses (%.1f%% hit rate)", hits, misses, float64(hits)*100/float64(total))
+	}
+	log.Print(recorder.Summary())
+	if batch {
+		log.Print(batchSummary(results))
+	}
+	if *reportPath != "" {
+		report := recorder.Report()
+		report.Input = *inputFile
+		report.Output = *outputFile
+		report.TargetLang = strings.Join(langs, ", ")
+		report.Start = start
+		report.DurationMS = time.Since(start).Milliseconds()
+		if err := writeReport(*reportPath, report); err != nil {
+			log.Printf("Error writing run report: %v", err)
+		}
+	}
+	if batch {
+		if failed := countFailed(results); failed > 0 {
+			log.Fatalf("Error translating %d of %d files", failed, len(results))
+		}
+		if ctx.Err() != nil {
+			log.Fatalf("Error translating files: %v", ctx.Err())
+		}
+	} else if err != nil {
+		var translationErr *sub.TranslationError
+		if errors.As(err, &translationErr) {
+			item, line, seg := translationErr.ResumeIndex()
+			log.Printf("Resume with: -resume (or -from %d,%d,%d)", item, line, seg)
+		}
 		log.Fatalf("Error translating file: %v", err)
 	}
 	log.Printf("Translation completed")
 }
+
+// translateStream translates from a file or stdin to a file or stdout, "-" means the standard stream. The formats of
+// files default to their extensions.
+func translateStream(ctx context.Context, inputPath, outputPath string, t sub.Translator, opts sub.Options) error {
+	var err error
+	in := io.Reader(os.Stdin)
+	if inputPath != "-" {
+		if opts.InputFormat == "" {
+			if opts.InputFormat, err = sub.FormatOf(inputPath); err != nil {
+				return err
+			}
+		}
+		f, err := os.Open(inputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if outputPath == "-" {
+		return sub.TranslateReader(ctx, in, os.Stdout, t, opts)
+	}
+
+	if opts.OutputFormat == "" {
+		if opts.OutputFormat, err = sub.FormatOf(outputPath); err != nil {
+			return err
+		}
+	}
+	var out bytes.Buffer
+	if err := sub.TranslateReader(ctx, in, &out, t, opts); err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, out.Bytes(), 0644)
+}
+
+// glossaryHash returns the hash of the glossary terms, empty if there are none.
+func glossaryHash(g config.Glossary) string {
+	if g.Empty() {
+		return ""
+	}
+	data, err := json.Marshal(g)
+	if err != nil {
+		log.Fatalf("Error hashing glossary: %v", err)
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func writeReport(path string, report translator.Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func openCache(cfg *config.Config) (*cache.Cache, error) {
+	path := cfg.Cache.Path
+	if path == "" {
+		var err error
+		path, err = cache.DefaultPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("cache file: %s", path)
+	return cache.Open(path)
+}
+
+func clearTranslationCache(configPath string) {
+	confPath, err := config.FindConfig(configPath)
+	if err != nil {
+		log.Fatalf("Error finding config file: %v", err)
+	}
+	cfg, err := config.Read(confPath)
+	if err != nil {
+		log.Fatalf("Error reading config file: %v", err)
+	}
+
+	c, err := openCache(cfg)
+	if err != nil {
+		log.Fatalf("Error opening translation cache: %v", err)
+	}
+	defer c.Close()
+	n, err := c.Len()
+	if err != nil {
+		log.Fatalf("Error reading translation cache: %v", err)
+	}
+	if err := c.Clear(); err != nil {
+		log.Fatalf("Error clearing translation cache: %v", err)
+	}
+	log.Printf("Cleared %d cached translations", n)
+}
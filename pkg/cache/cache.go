@@ -0,0 +1,115 @@
+// Package cache stores translations on disk, so re-running a file or translating lines seen before does not
+// request them again.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketName = []byte("translations")
+
+// Cache is an on-disk key value store of translations, it is safe for concurrent use.
+type Cache struct {
+	db *bolt.DB
+}
+
+// DefaultPath returns the cache file in the user cache directory.
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "subtrans", "translations.db"), nil
+}
+
+// Open opens the cache file at path, creating it if it does not exist.
+func Open(path string) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	// the file is locked while open, fail instead of waiting forever for another run
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize cache %s: %w", path, err)
+	}
+	return &Cache{db: db}, nil
+}
+
+// Key returns the cache key of parts, parts are length prefixed so different splits never collide.
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		binary.Write(h, binary.LittleEndian, uint64(len(part)))
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the translation stored under key.
+func (c *Cache) Get(key string) (string, bool, error) {
+	var (
+		value string
+		ok    bool
+	)
+	err := c.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketName).Get([]byte(key)); v != nil {
+			value, ok = string(v), true
+		}
+		return nil
+	})
+	return value, ok, err
+}
+
+// Put stores all entries, mapping keys to translations, in one transaction.
+func (c *Cache) Put(entries map[string]string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		for key, value := range entries {
+			if err := b.Put([]byte(key), []byte(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Len returns the number of stored translations.
+func (c *Cache) Len() (int, error) {
+	n := 0
+	err := c.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucketName).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// Clear removes all stored translations.
+func (c *Cache) Clear() error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(bucketName); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(bucketName)
+		return err
+	})
+}
+
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
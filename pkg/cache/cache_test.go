@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subtrans", "translations.db")
+	c, err := Open(path)
+	require.NoError(t, err)
+
+	_, ok, err := c.Get("hello")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, c.Put(map[string]string{"hello": "hola", "bye": "adiós"}))
+	require.NoError(t, c.Close())
+
+	// entries survive reopening
+	c, err = Open(path)
+	require.NoError(t, err)
+	defer c.Close()
+
+	value, ok, err := c.Get("hello")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "hola", value)
+
+	n, err := c.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	require.NoError(t, c.Clear())
+	n, err = c.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, ok, err = c.Get("hello")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// another run can not open the cache while it is open, it fails instead of waiting
+	_, err = Open(path)
+	assert.Error(t, err)
+}
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, Key("a", "b"), Key("a", "b"))
+	assert.NotEqual(t, Key("ab", "c"), Key("a", "bc"))
+	assert.NotEqual(t, Key("a", ""), Key("a"))
+}
@@ -6,32 +6,180 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
 )
 
 const (
-	OpenAI           = "openai"
-	Gemini           = "gemini"
-	OpenAIJSONObject = "json_object"
-	OpenAIJSONSchema = "json_schema"
-	defaultMaxTokens = 128000 // llm usually works better on small context
+	OpenAI             = "openai"
+	Gemini             = "gemini"
+	Anthropic          = "anthropic"
+	Ollama             = "ollama"
+	TokenizerAuto      = "auto"
+	TokenizerCl100k    = "cl100k"
+	TokenizerO200k     = "o200k"
+	TokenizerP50k      = "p50k"
+	TokenizerHeuristic = "heuristic"
+	OpenAIJSONObject   = "json_object"
+	OpenAIJSONSchema   = "json_schema"
+	OpenAIToolCall     = "tool_call"
+	OpenAINone         = "none"
+	SourceFirst        = "source_first"
+	TranslationFirst   = "translation_first"
+	UnitSegment        = "segment"
+	UnitLine           = "line"
+	UnitCue            = "cue"
+	UnitSentence       = "sentence"
+	defaultMaxTokens   = 128000 // llm usually works better on small context
+	defaultConcurrency = 1
+	defaultMaxAttempts = 3
+	defaultRetryDelay  = time.Second
+	defaultMaxDelay    = 30 * time.Second
+)
+
+// defaultAnthropicMaxOutputTokens is the max_tokens of Anthropic requests, which the Messages API requires.
+const defaultAnthropicMaxOutputTokens = 8192
+
+const (
+	defaultAzureAPIVersion = "2024-10-21"
+	defaultVertexLocation  = "us-central1"
 )
 
 type LLMProvider struct {
-	API             string `yaml:"api"`
-	APIKey          string `yaml:"api_key"`
-	APIURL          string `yaml:"api_url"`
-	Model           string `yaml:"model"`
-	MaxTokens       int    `yaml:"max_tokens"`
-	StructureOutput string `yaml:"structure_output"` // only used for openai
+	API             string   `yaml:"api"`
+	APIKey          string   `yaml:"api_key"`
+	APIURL          string   `yaml:"api_url"`
+	Model           string   `yaml:"model"`
+	MaxTokens       int      `yaml:"max_tokens"`        // context window of the model
+	MaxInputTokens  int      `yaml:"max_input_tokens"`  // tokens of the prompt, defaults to max_tokens minus max_output_tokens
+	MaxOutputTokens int      `yaml:"max_output_tokens"` // tokens of the response, 0 means no limit
+	StructureOutput string   `yaml:"structure_output"`  // only used for openai, "json_schema", "json_object", "tool_call" or "none"
+	Concurrency     int      `yaml:"concurrency"`       // number of batches translated in parallel
+	RPM             int      `yaml:"rpm"`               // requests per minute, 0 means no limit
+	TPM             int      `yaml:"tpm"`               // estimated tokens per minute, 0 means no limit
+	Fallback        []string `yaml:"fallback"`          // providers a batch is sent to in order when this one fails
+	Tokenizer       string   `yaml:"tokenizer"`         // "auto", "cl100k", "o200k", "p50k" or "heuristic"
+	CharsPerToken   float64  `yaml:"chars_per_token"`   // Latin characters per token of the heuristic tokenizer
+	Azure           *Azure   `yaml:"azure"`             // only used for openai, sends requests to an Azure OpenAI deployment
+	Vertex          *Vertex  `yaml:"vertex"`            // only used for gemini, sends requests to Vertex AI
+}
+
+// Azure selects an Azure OpenAI deployment, authenticated with the api_key in the api-key header.
+type Azure struct {
+	Endpoint   string `yaml:"endpoint"`    // resource endpoint, e.g. https://my-resource.openai.azure.com
+	Deployment string `yaml:"deployment"`  // deployment name, defaults to the model
+	APIVersion string `yaml:"api_version"` // defaults to 2024-10-21
+}
+
+// Vertex selects Vertex AI, authenticated with Google Cloud credentials instead of an api_key.
+type Vertex struct {
+	Project         string `yaml:"project"`
+	Location        string `yaml:"location"`         // defaults to us-central1
+	CredentialsFile string `yaml:"credentials_file"` // service account key, defaults to the application default credentials
+}
+
+// Retry controls how failed LLM requests are retried with exponential backoff.
+type Retry struct {
+	MaxAttempts  int           `yaml:"max_attempts"`  // attempts per request including the first one
+	InitialDelay time.Duration `yaml:"initial_delay"` // backoff before the first retry, doubled on every retry
+	MaxDelay     time.Duration `yaml:"max_delay"`     // upper bound of the backoff
+	Timeout      time.Duration `yaml:"timeout"`       // timeout of a single request, 0 means no timeout
+}
+
+// Sentence controls how the sentence unit groups consecutive cues into one sentence.
+type Sentence struct {
+	MaxGap  time.Duration `yaml:"max_gap"`  // longest pause between two cues of one sentence, defaults to 1s
+	MaxCues int           `yaml:"max_cues"` // largest number of cues in one sentence, defaults to 3
+}
+
+// SSA selects the events of ASS/SSA subtitles to translate, empty lists select all. Names match ignoring case.
+type SSA struct {
+	IncludeStyles []string `yaml:"include_styles"` // translate only the events of these styles
+	ExcludeStyles []string `yaml:"exclude_styles"` // keep the events of these styles untranslated, such as signs and songs
+	IncludeActors []string `yaml:"include_actors"` // translate only the events of these actors
+	ExcludeActors []string `yaml:"exclude_actors"` // keep the events of these actors untranslated
+}
+
+// Cache controls the on-disk cache of translations.
+type Cache struct {
+	Disabled       bool   `yaml:"disabled"`
+	Path           string `yaml:"path"`            // cache file, defaults to subtrans/translations.db in the user cache directory
+	IncludeContext bool   `yaml:"include_context"` // only reuse translations made with the same context lines
+}
+
+// Pricing is the price of a model in USD per million tokens.
+type Pricing struct {
+	Input       float64 `yaml:"input"`
+	CachedInput float64 `yaml:"cached_input"` // prompt tokens read from the prompt cache, defaults to input
+	Output      float64 `yaml:"output"`       // also charged for reasoning tokens
+}
+
+// Bilingual controls writing the source text together with its translation.
+type Bilingual struct {
+	Enabled   bool   `yaml:"enabled"`
+	Order     string `yaml:"order"`     // "source_first" or "translation_first"
+	Separator string `yaml:"separator"` // joins both languages on the same line, empty stacks them
 }
 
 type Config struct {
-	DefaultLLM string                 `yaml:"default_llm"`
-	LLMs       map[string]LLMProvider `yaml:"llms"`
-	TargetLang string                 `yaml:"target_lang"`
-	Prompts    map[string]string      `yaml:"prompts"`
+	DefaultLLM    string                 `yaml:"default_llm"`
+	LLMs          map[string]LLMProvider `yaml:"llms"`
+	TargetLang    string                 `yaml:"-"`           // language translators translate to, defaults to the first of TargetLangs
+	TargetLangs   Langs                  `yaml:"target_lang"` // one language or a list, each written to its own output
+	Prompts       map[string]string      `yaml:"prompts"`
+	ContextBefore int                    `yaml:"context_before"` // preceding lines sent with each batch as context
+	ContextAfter  int                    `yaml:"context_after"`  // following lines sent with each batch as context
+	Retry         Retry                  `yaml:"retry"`
+	Bilingual     Bilingual              `yaml:"bilingual"`
+	Unit          string                 `yaml:"unit"` // "segment", "line", "cue" or "sentence", the part of a subtitle translated as one text
+	Sentence      Sentence               `yaml:"sentence"`
+	SSA           SSA                    `yaml:"ssa"`
+	Cache         Cache                  `yaml:"cache"`
+	Glossary      Glossary               `yaml:"glossary"`
+	// ExpansionFactors maps target languages to the expected ratio of translation to source tokens.
+	ExpansionFactors map[string]float64 `yaml:"expansion_factors"`
+	// Pricing maps models to their prices, used to report the cost of a run.
+	Pricing map[string]Pricing `yaml:"pricing"`
+	// MultiLangPrompt translates every batch to all target languages in one request.
+	MultiLangPrompt bool `yaml:"multi_lang_prompt"`
+}
+
+// Langs is a list of languages, a single language may be written as a string.
+type Langs []string
+
+func (l *Langs) UnmarshalYAML(unmarshal func(any) error) error {
+	var lang string
+	if err := unmarshal(&lang); err == nil {
+		*l = nil
+		if lang != "" {
+			*l = Langs{lang}
+		}
+		return nil
+	}
+	var langs []string
+	if err := unmarshal(&langs); err != nil {
+		return err
+	}
+	*l = langs
+	return nil
+}
+
+// ParseLangs splits a comma-separated list of languages.
+func ParseLangs(s string) Langs {
+	return Langs(ParseList(s))
+}
+
+// ParseList splits a comma-separated list, empty entries are dropped.
+func ParseList(s string) []string {
+	var list []string
+	for entry := range strings.SplitSeq(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
 }
 
 func (c *Config) validate() error {
@@ -53,12 +201,70 @@ func (c *Config) validate() error {
 		return errors.New("default LLM provider not found in LLMs map")
 	}
 
+	if c.ContextBefore < 0 || c.ContextAfter < 0 {
+		return errors.New("context_before and context_after must not be negative")
+	}
+
+	if err := c.Retry.validate(); err != nil {
+		return err
+	}
+
+	if c.Bilingual.Order == "" {
+		c.Bilingual.Order = SourceFirst
+	}
+	if c.Bilingual.Order != SourceFirst && c.Bilingual.Order != TranslationFirst {
+		return errors.New("bilingual order must be source_first or translation_first")
+	}
+
+	if c.Unit == "" {
+		c.Unit = UnitSegment
+	}
+	if c.Unit != UnitSegment && c.Unit != UnitLine && c.Unit != UnitCue && c.Unit != UnitSentence {
+		return errors.New("unit must be segment, line, cue or sentence")
+	}
+	if c.Sentence.MaxGap < 0 || c.Sentence.MaxCues < 0 {
+		return errors.New("sentence settings must not be negative")
+	}
+
+	if err := c.Glossary.validate(); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, lang := range c.TargetLangs {
+		if lang == "" || seen[lang] {
+			return errors.New("target_lang must not contain empty or duplicate languages")
+		}
+		seen[lang] = true
+	}
+	if len(c.TargetLangs) > 0 {
+		c.TargetLang = c.TargetLangs[0]
+	}
+
+	for lang, factor := range c.ExpansionFactors {
+		if factor <= 0 {
+			return fmt.Errorf("expansion factor of '%s' must be positive", lang)
+		}
+	}
+	for model, pricing := range c.Pricing {
+		if pricing.Input < 0 || pricing.CachedInput < 0 || pricing.Output < 0 {
+			return fmt.Errorf("pricing of '%s' must not be negative", model)
+		}
+	}
+
 	// Validate each LLM provider
 	for name, provider := range c.LLMs {
 		if err := c.validateLLMProvider(name, provider); err != nil {
 			return err
 		}
 	}
+	for name, provider := range c.LLMs {
+		for _, fallback := range provider.Fallback {
+			if _, exists := c.LLMs[fallback]; !exists || fallback == name {
+				return fmt.Errorf("invalid fallback '%s' for LLM provider '%s'", fallback, name)
+			}
+		}
+	}
 
 	// Initialize empty prompts map if nil
 	if c.Prompts == nil {
@@ -69,7 +275,7 @@ func (c *Config) validate() error {
 }
 
 func (c *Config) validateLLMProvider(name string, provider LLMProvider) error {
-	if provider.API != OpenAI && provider.API != Gemini {
+	if provider.API != OpenAI && provider.API != Gemini && provider.API != Anthropic && provider.API != Ollama {
 		return fmt.Errorf("invalid api for LLM provider '%s'", name)
 	}
 	if provider.API == OpenAI {
@@ -77,23 +283,107 @@ func (c *Config) validateLLMProvider(name string, provider LLMProvider) error {
 			// Set default structure output for OpenAI
 			provider.StructureOutput = OpenAIJSONSchema
 		}
-		if provider.StructureOutput != OpenAIJSONObject && provider.StructureOutput != OpenAIJSONSchema {
+		switch provider.StructureOutput {
+		case OpenAIJSONObject, OpenAIJSONSchema, OpenAIToolCall, OpenAINone:
+		default:
 			return fmt.Errorf("invalid structure_output for LLM provider '%s'", name)
 		}
 	}
-	if provider.APIKey == "" {
+	if provider.Azure != nil {
+		if provider.API != OpenAI {
+			return fmt.Errorf("azure is only supported by the openai api for LLM provider '%s'", name)
+		}
+		if provider.Azure.Endpoint == "" {
+			return fmt.Errorf("azure endpoint is required for LLM provider '%s'", name)
+		}
+		azure := *provider.Azure
+		if azure.Deployment == "" {
+			azure.Deployment = provider.Model
+		}
+		if azure.APIVersion == "" {
+			azure.APIVersion = defaultAzureAPIVersion
+		}
+		provider.Azure = &azure
+	}
+	if provider.Vertex != nil {
+		if provider.API != Gemini {
+			return fmt.Errorf("vertex is only supported by the gemini api for LLM provider '%s'", name)
+		}
+		if provider.Vertex.Project == "" {
+			return fmt.Errorf("vertex project is required for LLM provider '%s'", name)
+		}
+		vertex := *provider.Vertex
+		if vertex.Location == "" {
+			vertex.Location = defaultVertexLocation
+		}
+		provider.Vertex = &vertex
+	}
+	// a local Ollama server needs no key, Vertex AI uses Google Cloud credentials
+	if provider.APIKey == "" && provider.API != Ollama && provider.Vertex == nil {
 		return fmt.Errorf("api_key is required for LLM provider '%s'", name)
 	}
 	if provider.Model == "" {
 		return fmt.Errorf("model is required for LLM provider '%s'", name)
 	}
-	if provider.MaxTokens == 0 {
+	if provider.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative for LLM provider '%s'", name)
+	}
+	// Ollama asks the server for the context length of the model instead
+	if provider.MaxTokens == 0 && provider.API != Ollama {
 		provider.MaxTokens = defaultMaxTokens
 	}
+	if provider.MaxInputTokens < 0 {
+		return fmt.Errorf("max_input_tokens must not be negative for LLM provider '%s'", name)
+	}
+	if provider.MaxOutputTokens < 0 {
+		return fmt.Errorf("max_output_tokens must not be negative for LLM provider '%s'", name)
+	}
+	if provider.API == Anthropic && provider.MaxOutputTokens == 0 {
+		provider.MaxOutputTokens = defaultAnthropicMaxOutputTokens
+	}
+	if provider.Tokenizer == "" {
+		provider.Tokenizer = TokenizerAuto
+	}
+	switch provider.Tokenizer {
+	case TokenizerAuto, TokenizerCl100k, TokenizerO200k, TokenizerP50k, TokenizerHeuristic:
+	default:
+		return fmt.Errorf("invalid tokenizer for LLM provider '%s'", name)
+	}
+	if provider.CharsPerToken < 0 {
+		return fmt.Errorf("chars_per_token must not be negative for LLM provider '%s'", name)
+	}
+	if provider.Concurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative for LLM provider '%s'", name)
+	}
+	if provider.RPM < 0 || provider.TPM < 0 {
+		return fmt.Errorf("rpm and tpm must not be negative for LLM provider '%s'", name)
+	}
+	if provider.Concurrency == 0 {
+		provider.Concurrency = defaultConcurrency
+	}
+	if c.LLMs == nil {
+		c.LLMs = map[string]LLMProvider{}
+	}
 	c.LLMs[name] = provider
 	return nil
 }
 
+func (r *Retry) validate() error {
+	if r.MaxAttempts < 0 || r.InitialDelay < 0 || r.MaxDelay < 0 || r.Timeout < 0 {
+		return errors.New("retry settings must not be negative")
+	}
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = defaultMaxAttempts
+	}
+	if r.InitialDelay == 0 {
+		r.InitialDelay = defaultRetryDelay
+	}
+	if r.MaxDelay == 0 {
+		r.MaxDelay = defaultMaxDelay
+	}
+	return nil
+}
+
 // GetDefaultLLM returns the default LLM provider
 func (c *Config) GetDefaultLLM() (LLMProvider, error) {
 	provider, exists := c.LLMs[c.DefaultLLM]
@@ -114,6 +404,14 @@ func (c *Config) GetLLM(name string) (LLMProvider, error) {
 	return provider, nil
 }
 
+// ResolveLLM returns the LLM provider by name, "default" selects the default provider
+func (c *Config) ResolveLLM(name string) (LLMProvider, error) {
+	if name == "default" {
+		return c.GetDefaultLLM()
+	}
+	return c.GetLLM(name)
+}
+
 func Read(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
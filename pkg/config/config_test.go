@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -49,6 +50,104 @@ func TestConfig_validate(t *testing.T) {
 			},
 			wantErr: "default LLM provider not found in LLMs map",
 		},
+		{
+			name: "negative context",
+			config: Config{
+				DefaultLLM: "openai",
+				LLMs: map[string]LLMProvider{
+					"openai": {API: OpenAI, APIKey: "key", Model: "gpt-4"},
+				},
+				ContextBefore: -1,
+			},
+			wantErr: "context_before and context_after must not be negative",
+		},
+		{
+			name: "negative retry",
+			config: Config{
+				DefaultLLM: "openai",
+				LLMs: map[string]LLMProvider{
+					"openai": {API: OpenAI, APIKey: "key", Model: "gpt-4"},
+				},
+				Retry: Retry{MaxAttempts: -1},
+			},
+			wantErr: "retry settings must not be negative",
+		},
+		{
+			name: "invalid bilingual order",
+			config: Config{
+				DefaultLLM: "openai",
+				LLMs: map[string]LLMProvider{
+					"openai": {API: OpenAI, APIKey: "key", Model: "gpt-4"},
+				},
+				Bilingual: Bilingual{Enabled: true, Order: "random"},
+			},
+			wantErr: "bilingual order must be source_first or translation_first",
+		},
+		{
+			name: "unknown fallback",
+			config: Config{
+				DefaultLLM: "openai",
+				LLMs: map[string]LLMProvider{
+					"openai": {API: OpenAI, APIKey: "key", Model: "gpt-4", Fallback: []string{"claude"}},
+				},
+			},
+			wantErr: "invalid fallback 'claude' for LLM provider 'openai'",
+		},
+		{
+			name: "fallback to itself",
+			config: Config{
+				DefaultLLM: "openai",
+				LLMs: map[string]LLMProvider{
+					"openai": {API: OpenAI, APIKey: "key", Model: "gpt-4", Fallback: []string{"openai"}},
+				},
+			},
+			wantErr: "invalid fallback 'openai' for LLM provider 'openai'",
+		},
+		{
+			name: "invalid unit",
+			config: Config{
+				DefaultLLM: "openai",
+				LLMs: map[string]LLMProvider{
+					"openai": {API: OpenAI, APIKey: "key", Model: "gpt-4"},
+				},
+				Unit: "word",
+			},
+			wantErr: "unit must be segment, line, cue or sentence",
+		},
+		{
+			name: "negative sentence settings",
+			config: Config{
+				DefaultLLM: "openai",
+				LLMs: map[string]LLMProvider{
+					"openai": {API: OpenAI, APIKey: "key", Model: "gpt-4"},
+				},
+				Unit:     UnitSentence,
+				Sentence: Sentence{MaxCues: -1},
+			},
+			wantErr: "sentence settings must not be negative",
+		},
+		{
+			name: "non-positive expansion factor",
+			config: Config{
+				DefaultLLM: "openai",
+				LLMs: map[string]LLMProvider{
+					"openai": {API: OpenAI, APIKey: "key", Model: "gpt-4"},
+				},
+				ExpansionFactors: map[string]float64{"German": 0},
+			},
+			wantErr: "expansion factor of 'German' must be positive",
+		},
+		{
+			name: "negative pricing",
+			config: Config{
+				DefaultLLM: "openai",
+				LLMs: map[string]LLMProvider{
+					"openai": {API: OpenAI, APIKey: "key", Model: "gpt-4"},
+				},
+				Pricing: map[string]Pricing{"gpt-4": {Input: 30, Output: -60}},
+			},
+			wantErr: "pricing of 'gpt-4' must not be negative",
+		},
 		{
 			name: "valid config",
 			config: Config{
@@ -129,18 +228,118 @@ func TestConfig_validateLLMProvider(t *testing.T) {
 			provider: LLMProvider{API: OpenAI, APIKey: "key", Model: "gpt-4", StructureOutput: OpenAIJSONSchema},
 			wantErr:  "",
 		},
+		{
+			name:     "valid OpenAI provider with tool_call",
+			llmName:  "test",
+			provider: LLMProvider{API: OpenAI, APIKey: "key", Model: "gpt-4", StructureOutput: OpenAIToolCall},
+			wantErr:  "",
+		},
+		{
+			name:     "valid OpenAI provider with none",
+			llmName:  "test",
+			provider: LLMProvider{API: OpenAI, APIKey: "key", Model: "gpt-4", StructureOutput: OpenAINone},
+			wantErr:  "",
+		},
 		{
 			name:     "valid OpenAI provider with default structure_output",
 			llmName:  "test",
 			provider: LLMProvider{API: OpenAI, APIKey: "key", Model: "gpt-4"},
 			wantErr:  "",
 		},
+		{
+			name:     "negative concurrency",
+			llmName:  "test",
+			provider: LLMProvider{API: Gemini, APIKey: "key", Model: "gemini-pro", Concurrency: -1},
+			wantErr:  "concurrency must not be negative for LLM provider 'test'",
+		},
 		{
 			name:     "valid Gemini provider",
 			llmName:  "test",
 			provider: LLMProvider{API: Gemini, APIKey: "key", Model: "gemini-pro"},
 			wantErr:  "",
 		},
+		{
+			name:     "valid Anthropic provider",
+			llmName:  "test",
+			provider: LLMProvider{API: Anthropic, APIKey: "key", Model: "claude-sonnet-4-5"},
+			wantErr:  "",
+		},
+		{
+			name:     "valid Ollama provider without API key",
+			llmName:  "test",
+			provider: LLMProvider{API: Ollama, Model: "llama3"},
+			wantErr:  "",
+		},
+		{
+			name:     "negative max_tokens",
+			llmName:  "test",
+			provider: LLMProvider{API: Ollama, Model: "llama3", MaxTokens: -1},
+			wantErr:  "max_tokens must not be negative for LLM provider 'test'",
+		},
+		{
+			name:     "invalid tokenizer",
+			llmName:  "test",
+			provider: LLMProvider{API: OpenAI, APIKey: "key", Model: "gpt-4o", Tokenizer: "bpe"},
+			wantErr:  "invalid tokenizer for LLM provider 'test'",
+		},
+		{
+			name:     "negative chars_per_token",
+			llmName:  "test",
+			provider: LLMProvider{API: Gemini, APIKey: "key", Model: "gemini-pro", Tokenizer: TokenizerHeuristic, CharsPerToken: -1},
+			wantErr:  "chars_per_token must not be negative for LLM provider 'test'",
+		},
+		{
+			name:     "negative max_output_tokens",
+			llmName:  "test",
+			provider: LLMProvider{API: Anthropic, APIKey: "key", Model: "claude-sonnet-4-5", MaxOutputTokens: -1},
+			wantErr:  "max_output_tokens must not be negative for LLM provider 'test'",
+		},
+		{
+			name:     "negative rpm",
+			llmName:  "test",
+			provider: LLMProvider{API: OpenAI, APIKey: "key", Model: "gpt-4o", RPM: -1, TPM: 30000},
+			wantErr:  "rpm and tpm must not be negative for LLM provider 'test'",
+		},
+		{
+			name:     "negative max_input_tokens",
+			llmName:  "test",
+			provider: LLMProvider{API: OpenAI, APIKey: "key", Model: "gpt-4o", MaxInputTokens: -1},
+			wantErr:  "max_input_tokens must not be negative for LLM provider 'test'",
+		},
+		{
+			name:     "input and output limits",
+			llmName:  "test",
+			provider: LLMProvider{API: OpenAI, APIKey: "key", Model: "gpt-4o", MaxInputTokens: 100000, MaxOutputTokens: 16384},
+		},
+		{
+			name:     "azure without endpoint",
+			llmName:  "test",
+			provider: LLMProvider{API: OpenAI, APIKey: "key", Model: "gpt-4o", Azure: &Azure{Deployment: "prod"}},
+			wantErr:  "azure endpoint is required for LLM provider 'test'",
+		},
+		{
+			name:     "azure with gemini",
+			llmName:  "test",
+			provider: LLMProvider{API: Gemini, APIKey: "key", Model: "gemini-pro", Azure: &Azure{Endpoint: "https://r.openai.azure.com"}},
+			wantErr:  "azure is only supported by the openai api for LLM provider 'test'",
+		},
+		{
+			name:     "vertex without project",
+			llmName:  "test",
+			provider: LLMProvider{API: Gemini, Model: "gemini-pro", Vertex: &Vertex{Location: "europe-west4"}},
+			wantErr:  "vertex project is required for LLM provider 'test'",
+		},
+		{
+			name:     "vertex with openai",
+			llmName:  "test",
+			provider: LLMProvider{API: OpenAI, APIKey: "key", Model: "gpt-4o", Vertex: &Vertex{Project: "p"}},
+			wantErr:  "vertex is only supported by the gemini api for LLM provider 'test'",
+		},
+		{
+			name:     "vertex without API key",
+			llmName:  "test",
+			provider: LLMProvider{API: Gemini, Model: "gemini-pro", Vertex: &Vertex{Project: "p"}},
+		},
 	}
 
 	for _, tt := range tests {
@@ -157,6 +356,31 @@ func TestConfig_validateLLMProvider(t *testing.T) {
 	}
 }
 
+func TestConfig_validateLLMProviderAnthropicDefaults(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.validateLLMProvider("claude", LLMProvider{API: Anthropic, APIKey: "key", Model: "claude-sonnet-4-5"}))
+	assert.Equal(t, defaultAnthropicMaxOutputTokens, c.LLMs["claude"].MaxOutputTokens)
+	assert.Equal(t, defaultMaxTokens, c.LLMs["claude"].MaxTokens)
+}
+
+func TestConfig_validateLLMProviderOllamaDefaults(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.validateLLMProvider("local", LLMProvider{API: Ollama, Model: "llama3"}))
+	// left for the translator to query from the server
+	assert.Equal(t, 0, c.LLMs["local"].MaxTokens)
+}
+
+func TestConfig_validateLLMProviderCloudDefaults(t *testing.T) {
+	c := &Config{}
+	azure := &Azure{Endpoint: "https://r.openai.azure.com"}
+	require.NoError(t, c.validateLLMProvider("azure", LLMProvider{API: OpenAI, APIKey: "key", Model: "gpt-4o", Azure: azure}))
+	assert.Equal(t, &Azure{Endpoint: "https://r.openai.azure.com", Deployment: "gpt-4o", APIVersion: defaultAzureAPIVersion}, c.LLMs["azure"].Azure)
+	assert.Empty(t, azure.Deployment, "the given struct is not changed")
+
+	require.NoError(t, c.validateLLMProvider("vertex", LLMProvider{API: Gemini, Model: "gemini-pro", Vertex: &Vertex{Project: "p"}}))
+	assert.Equal(t, &Vertex{Project: "p", Location: defaultVertexLocation}, c.LLMs["vertex"].Vertex)
+}
+
 func TestConfig_GetDefaultLLM(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -272,6 +496,71 @@ target_lang: en
 			validate: func(t *testing.T, c *Config) {
 				assert.Equal(t, "openai", c.DefaultLLM)
 				assert.Equal(t, "en", c.TargetLang)
+				assert.Equal(t, Langs{"en"}, c.TargetLangs)
+				assert.Equal(t, Retry{MaxAttempts: 3, InitialDelay: time.Second, MaxDelay: 30 * time.Second}, c.Retry)
+			},
+		},
+		{
+			name: "target languages",
+			content: `default_llm: openai
+llms:
+  openai:
+    api: openai
+    api_key: test-key
+    model: gpt-4
+target_lang: [German, French]
+multi_lang_prompt: true
+`,
+			validate: func(t *testing.T, c *Config) {
+				assert.Equal(t, Langs{"German", "French"}, c.TargetLangs)
+				assert.Equal(t, "German", c.TargetLang)
+				assert.True(t, c.MultiLangPrompt)
+			},
+		},
+		{
+			name: "duplicate target languages",
+			content: `default_llm: openai
+llms:
+  openai:
+    api: openai
+    api_key: test-key
+    model: gpt-4
+target_lang: [German, German]
+`,
+			wantErr: true,
+		},
+		{
+			name: "ssa filters",
+			content: `default_llm: openai
+llms:
+  openai:
+    api: openai
+    api_key: test-key
+    model: gpt-4
+ssa:
+  exclude_styles: [Signs, Song]
+  include_actors: [Bob]
+`,
+			validate: func(t *testing.T, c *Config) {
+				assert.Equal(t, SSA{ExcludeStyles: []string{"Signs", "Song"}, IncludeActors: []string{"Bob"}}, c.SSA)
+			},
+		},
+		{
+			name: "retry config",
+			content: `default_llm: openai
+llms:
+  openai:
+    api: openai
+    api_key: test-key
+    model: gpt-4
+retry:
+  max_attempts: 5
+  initial_delay: 500ms
+  timeout: 2m
+`,
+			wantErr: false,
+			validate: func(t *testing.T, c *Config) {
+				assert.Equal(t, Retry{MaxAttempts: 5, InitialDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second, Timeout: 2 * time.Minute}, c.Retry)
 			},
 		},
 		{
@@ -360,3 +649,10 @@ func TestFindConfig(t *testing.T) {
 		_ = err
 	})
 }
+
+func TestParseLangs(t *testing.T) {
+	assert.Equal(t, Langs{"German", "French", "Simplified Chinese"}, ParseLangs("German, French,,Simplified Chinese "))
+	assert.Equal(t, Langs{"German"}, ParseLangs("German"))
+	assert.Nil(t, ParseLangs(""))
+	assert.Equal(t, []string{"Signs", "Song OP"}, ParseList("Signs, Song OP,"))
+}
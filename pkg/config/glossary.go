@@ -0,0 +1,72 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Glossary lists terms that must be translated consistently.
+type Glossary struct {
+	Terms   map[string]string `yaml:"terms"`   // source term to its required translation
+	Keep    []string          `yaml:"keep"`    // terms left untranslated, e.g. names
+	Retries int               `yaml:"retries"` // re-requests of lines missing a term, 0 only logs them
+}
+
+// Empty reports whether the glossary has no terms.
+func (g Glossary) Empty() bool {
+	return len(g.Terms) == 0 && len(g.Keep) == 0
+}
+
+func (g *Glossary) validate() error {
+	if g.Retries < 0 {
+		return errors.New("glossary retries must not be negative")
+	}
+	for source, target := range g.Terms {
+		if source == "" || target == "" {
+			return fmt.Errorf("glossary term %q: source and target must not be empty", source)
+		}
+	}
+	if slices.Contains(g.Keep, "") {
+		return errors.New("glossary keep terms must not be empty")
+	}
+	return nil
+}
+
+// Merge adds the terms of other, they replace terms of g with the same source.
+func (g *Glossary) Merge(other Glossary) {
+	if len(other.Terms) > 0 && g.Terms == nil {
+		g.Terms = map[string]string{}
+	}
+	for source, target := range other.Terms {
+		g.Terms[source] = target
+	}
+	for _, term := range other.Keep {
+		if !slices.Contains(g.Keep, term) {
+			g.Keep = append(g.Keep, term)
+		}
+	}
+	if other.Retries > 0 {
+		g.Retries = other.Retries
+	}
+}
+
+// ReadGlossary reads a glossary file, it has the same fields as the glossary section of the config.
+func ReadGlossary(path string) (Glossary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Glossary{}, err
+	}
+
+	var g Glossary
+	if err := yaml.Unmarshal(data, &g); err != nil {
+		return Glossary{}, err
+	}
+	if err := g.validate(); err != nil {
+		return Glossary{}, err
+	}
+	return g, nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadGlossary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "glossary.yaml")
+	content := `terms:
+  Frodo: 佛罗多
+  the Shire: 夏尔
+keep:
+  - Gandalf
+retries: 2
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	g, err := ReadGlossary(path)
+	require.NoError(t, err)
+	assert.Equal(t, Glossary{
+		Terms:   map[string]string{"Frodo": "佛罗多", "the Shire": "夏尔"},
+		Keep:    []string{"Gandalf"},
+		Retries: 2,
+	}, g)
+
+	require.NoError(t, os.WriteFile(path, []byte("terms:\n  Frodo: \"\"\n"), 0644))
+	_, err = ReadGlossary(path)
+	assert.EqualError(t, err, `glossary term "Frodo": source and target must not be empty`)
+
+	require.NoError(t, os.WriteFile(path, []byte("retries: -1\n"), 0644))
+	_, err = ReadGlossary(path)
+	assert.EqualError(t, err, "glossary retries must not be negative")
+}
+
+func TestGlossaryMerge(t *testing.T) {
+	g := Glossary{Keep: []string{"Gandalf"}, Retries: 1}
+	g.Merge(Glossary{
+		Terms: map[string]string{"Frodo": "佛罗多"},
+		Keep:  []string{"Gandalf", "Bree"},
+	})
+	assert.Equal(t, Glossary{
+		Terms:   map[string]string{"Frodo": "佛罗多"},
+		Keep:    []string{"Gandalf", "Bree"},
+		Retries: 1,
+	}, g)
+
+	g.Merge(Glossary{Terms: map[string]string{"Frodo": "弗罗多"}, Retries: 3})
+	assert.Equal(t, "弗罗多", g.Terms["Frodo"])
+	assert.Equal(t, 3, g.Retries)
+	assert.False(t, g.Empty())
+	assert.True(t, Glossary{Retries: 1}.Empty())
+}
@@ -0,0 +1,140 @@
+package sub
+
+import (
+	"io"
+	"slices"
+
+	"github.com/asticode/go-astisub"
+)
+
+const (
+	secondaryStyleSuffix = " Secondary"
+	secondaryFontScale   = 0.75
+)
+
+// Bilingual keeps the source text together with its translation in the output.
+type Bilingual struct {
+	Enabled bool
+	// TranslationFirst puts the translation before the source text, it is the primary language in ASS/SSA output.
+	TranslationFirst bool
+	// Separator joins source and translation on the same line, empty stacks them on separate lines.
+	// It is ignored for ASS/SSA output, where each language is written as its own event.
+	Separator string
+}
+
+// output writes translated subtitles in format to path, or to w if it is set.
+type output struct {
+	path string
+	// w receives the complete translation only, partial translations are written to paths alone
+	w      io.Writer
+	format Format
+	// ssa restores the events of ASS/SSA input, nil for other formats
+	ssa ssaEvents
+	// source holds the untranslated subtitles, prepared like the translated ones, only set for bilingual output
+	source    *astisub.Subtitles
+	bilingual Bilingual
+}
+
+func (o output) write(subs *astisub.Subtitles) error {
+	source := o.source
+	var skipped []bool
+	if o.ssa != nil {
+		subs = o.ssa.restore(subs, o.format.isSSA())
+		if source != nil {
+			source = o.ssa.restore(source, o.format.isSSA())
+		}
+		skipped = o.ssa.skipped()
+	}
+	if o.bilingual.Enabled {
+		subs = composeBilingual(source, subs, skipped, o.bilingual, o.format.isSSA())
+	}
+	if o.ssa != nil && !o.format.isSSA() {
+		// after composing, whose items match the events one to one in other formats
+		subs = o.ssa.dropDrawings(subs)
+	}
+	if o.w != nil {
+		return Write(o.w, subs, o.format)
+	}
+	return writeFile(o.path, subs, o.format)
+}
+
+// composeBilingual merges the items of source and translated, which have the same structure, into new subtitles.
+// Items marked in skipped were not translated, they are written once.
+func composeBilingual(source, translated *astisub.Subtitles, skipped []bool, b Bilingual, ssa bool) *astisub.Subtitles {
+	result := *translated
+	result.Items = make([]*astisub.Item, 0, len(translated.Items))
+	result.Styles = make(map[string]*astisub.Style, len(translated.Styles))
+	for id, style := range translated.Styles {
+		result.Styles[id] = style
+	}
+
+	for i, item := range translated.Items {
+		if i < len(skipped) && skipped[i] {
+			result.Items = append(result.Items, item)
+			continue
+		}
+		first, second := source.Items[i], item
+		if b.TranslationFirst {
+			first, second = second, first
+		}
+
+		if ssa {
+			primary := *first
+			secondary := *second
+			secondary.Style = secondaryStyle(&result, second.Style)
+			result.Items = append(result.Items, &primary, &secondary)
+			continue
+		}
+
+		merged := *item
+		if b.Separator == "" {
+			merged.Lines = append(slices.Clone(first.Lines), second.Lines...)
+		} else {
+			merged.Lines = joinLines(first.Lines, second.Lines, b.Separator)
+		}
+		result.Items = append(result.Items, &merged)
+	}
+	return &result
+}
+
+// joinLines puts the n-th line of second after the n-th line of first, separated by sep.
+func joinLines(first, second []astisub.Line, sep string) []astisub.Line {
+	lines := make([]astisub.Line, max(len(first), len(second)))
+	for i := range lines {
+		switch {
+		case i >= len(first):
+			lines[i] = second[i]
+		case i >= len(second):
+			lines[i] = first[i]
+		default:
+			lines[i] = first[i]
+			lines[i].Items = slices.Concat(first[i].Items, []astisub.LineItem{{Text: sep}}, second[i].Items)
+		}
+	}
+	return lines
+}
+
+// secondaryStyle returns the style for the secondary language derived from base, a smaller copy added to subs
+// so it can be sized and positioned independently.
+func secondaryStyle(subs *astisub.Subtitles, base *astisub.Style) *astisub.Style {
+	id := "Default"
+	attrs := astisub.StyleAttributes{}
+	if base != nil {
+		id = base.ID
+		if base.InlineStyle != nil {
+			attrs = *base.InlineStyle
+		}
+	}
+	id += secondaryStyleSuffix
+
+	if style, ok := subs.Styles[id]; ok {
+		return style
+	}
+	if attrs.SSAFontSize != nil {
+		size := *attrs.SSAFontSize * secondaryFontScale
+		attrs.SSAFontSize = &size
+	}
+	style := &astisub.Style{ID: id, InlineStyle: &attrs}
+	subs.Styles[id] = style
+	return style
+}
@@ -0,0 +1,125 @@
+package sub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const twoLinesSRT = `1
+00:00:01,000 --> 00:00:04,000
+Hello world
+
+2
+00:00:05,000 --> 00:00:08,000
+How are you?
+I am fine.
+`
+
+func TestTranslateFileBilingual(t *testing.T) {
+	translations := map[string]string{
+		"Hello world":  "Hola mundo",
+		"How are you?": "¿Cómo estás?",
+		"I am fine.":   "Estoy bien.",
+	}
+
+	tests := []struct {
+		name      string
+		bilingual Bilingual
+		want      string
+	}{
+		{
+			name:      "stacked source first",
+			bilingual: Bilingual{Enabled: true},
+			want: "\ufeff" + `1
+00:00:01,000 --> 00:00:04,000
+Hello world
+Hola mundo
+
+2
+00:00:05,000 --> 00:00:08,000
+How are you?
+I am fine.
+¿Cómo estás?
+Estoy bien.
+`,
+		},
+		{
+			name:      "same line translation first",
+			bilingual: Bilingual{Enabled: true, TranslationFirst: true, Separator: " / "},
+			want: "\ufeff" + `1
+00:00:01,000 --> 00:00:04,000
+Hola mundo / Hello world
+
+2
+00:00:05,000 --> 00:00:08,000
+¿Cómo estás? / How are you?
+Estoy bien. / I am fine.
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			tmpInput := filepath.Join(tmpDir, "input.srt")
+			tmpOutput := filepath.Join(tmpDir, "output.srt")
+			require.NoError(t, os.WriteFile(tmpInput, []byte(twoLinesSRT), 0644))
+
+			translator := &mockTranslator{translations: translations, maxLength: 10}
+			err := TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{Bilingual: tt.bilingual})
+			require.NoError(t, err)
+
+			outputContent, err := os.ReadFile(tmpOutput)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(outputContent))
+		})
+	}
+}
+
+func TestTranslateFileBilingualSSA(t *testing.T) {
+	inputContent := `[Script Info]
+ScriptType: v4.00+
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, Alignment
+Style: Default,Arial,40,&H00FFFFFF,2
+
+[Events]
+Format: Layer, Start, End, Style, Text
+Dialogue: 0,0:00:01.00,0:00:04.00,Default,Hello world
+`
+
+	tmpDir := t.TempDir()
+	tmpInput := filepath.Join(tmpDir, "input.ass")
+	tmpOutput := filepath.Join(tmpDir, "output.ass")
+	require.NoError(t, os.WriteFile(tmpInput, []byte(inputContent), 0644))
+
+	translator := &mockTranslator{translations: map[string]string{"Hello world": "Hola mundo"}, maxLength: 10}
+	err := TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{Bilingual: Bilingual{Enabled: true, TranslationFirst: true}})
+	require.NoError(t, err)
+
+	outputContent, err := os.ReadFile(tmpOutput)
+	require.NoError(t, err)
+	assert.Equal(t, `[Script Info]
+ScriptType: v4.00+
+
+[V4+ Styles]
+Format: Name, Alignment, Fontname, Fontsize, PrimaryColour
+Style: Default,2,Arial,40.000,&H00ffffff
+Style: Default Secondary,2,Arial,30.000,&H00ffffff
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,00:00:01.00,00:00:04.00,Default,,0,0,0,,Hola mundo
+Dialogue: 0,00:00:01.00,00:00:04.00,Default Secondary,,0,0,0,,Hello world
+`, string(outputContent))
+}
+
+func TestTranslateFileFromIndexBilingual(t *testing.T) {
+	err := TranslateFileFromIndex(t.Context(), "input.srt", "output.srt", &mockTranslator{}, 0, 0, 0, Options{Bilingual: Bilingual{Enabled: true}})
+	assert.ErrorContains(t, err, "bilingual output can not be resumed from an index")
+}
@@ -0,0 +1,154 @@
+package sub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/asticode/go-astisub"
+)
+
+const checkpointSuffix = ".checkpoint.json"
+
+// RunSettings identifies the settings of a translation run, a checkpoint is only resumed with identical settings.
+type RunSettings struct {
+	Provider   string `json:"provider"`
+	Model      string `json:"model"`
+	Prompt     string `json:"prompt"` // hash of the prompt template
+	TargetLang string `json:"target_lang"`
+	Unit       string `json:"unit"`
+}
+
+type completedText struct {
+	Item int    `json:"item"`
+	Line int    `json:"line"`
+	Seg  int    `json:"seg"`
+	Cues int    `json:"cues,omitempty"` // number of items of a sentence
+	Text string `json:"text"`
+}
+
+// checkpoint is the sidecar file next to the output recording every completed translation,
+// so an interrupted run can continue without re-translating them.
+type checkpoint struct {
+	path       string
+	SourceHash string          `json:"source_hash"`
+	Settings   RunSettings     `json:"settings"`
+	Completed  []completedText `json:"completed"`
+}
+
+// CheckpointPath returns the path of the checkpoint sidecar file for outputPath.
+func CheckpointPath(outputPath string) string {
+	return outputPath + checkpointSuffix
+}
+
+// openCheckpoint loads the checkpoint of outputPath when resuming, or starts a new one.
+func openCheckpoint(inputPath, outputPath string, opts Options) (*checkpoint, error) {
+	sourceHash, err := hashFile(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash input file: %w", err)
+	}
+
+	cp := &checkpoint{
+		path:       CheckpointPath(outputPath),
+		SourceHash: sourceHash,
+		Settings:   opts.Settings,
+	}
+
+	data, err := os.ReadFile(cp.path)
+	if errors.Is(err, os.ErrNotExist) {
+		if opts.Resume {
+			log.Printf("No checkpoint found at %s, starting from the beginning", cp.path)
+		}
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if !opts.Resume {
+		log.Printf("Overwriting existing checkpoint %s, use -resume to continue from it", cp.path)
+		return cp, nil
+	}
+
+	var saved checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", cp.path, err)
+	}
+	if saved.SourceHash != cp.SourceHash {
+		return nil, fmt.Errorf("checkpoint %s does not match: input file changed", cp.path)
+	}
+	if saved.Settings != cp.Settings {
+		return nil, fmt.Errorf("checkpoint %s does not match: settings changed from %+v to %+v", cp.path, saved.Settings, cp.Settings)
+	}
+	cp.Completed = saved.Completed
+	log.Printf("Resuming from checkpoint %s with %d completed items", cp.path, len(cp.Completed))
+	return cp, nil
+}
+
+// apply writes the completed translations into subs and returns the indices of infos still to translate.
+func (c *checkpoint) apply(subs *astisub.Subtitles, infos []textInfo, unit Unit) []int {
+	completed := map[[4]int]string{}
+	for _, t := range c.Completed {
+		completed[[4]int{t.Item, t.Line, t.Seg, t.Cues}] = t.Text
+	}
+
+	pending := []int{}
+	for i, info := range infos {
+		text, ok := completed[[4]int{info.itemIndex, info.lineIndex, info.segIndex, info.cues}]
+		if !ok {
+			pending = append(pending, i)
+			continue
+		}
+		setText(subs, info, text, unit)
+	}
+	return pending
+}
+
+func (c *checkpoint) add(info textInfo, text string) {
+	c.Completed = append(c.Completed, completedText{
+		Item: info.itemIndex,
+		Line: info.lineIndex,
+		Seg:  info.segIndex,
+		Cues: info.cues,
+		Text: text,
+	})
+}
+
+// save writes the checkpoint to a temporary file and renames it, so a crash never leaves a truncated checkpoint.
+func (c *checkpoint) save() error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+func (c *checkpoint) remove() error {
+	err := os.Remove(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
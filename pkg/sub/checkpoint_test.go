@@ -0,0 +1,106 @@
+package sub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslateFileCheckpointResume(t *testing.T) {
+	expected := "\ufeff" + `1
+00:00:01,000 --> 00:00:04,000
+Trans 1
+
+2
+00:00:05,000 --> 00:00:08,000
+Trans 2
+
+3
+00:00:09,000 --> 00:00:12,000
+Trans 3
+
+4
+00:00:13,000 --> 00:00:16,000
+Trans 4
+`
+
+	tmpDir := t.TempDir()
+	tmpInput := filepath.Join(tmpDir, "input.srt")
+	tmpOutput := filepath.Join(tmpDir, "output.srt")
+	require.NoError(t, os.WriteFile(tmpInput, []byte(fourLinesSRT), 0644))
+
+	translations := map[string]string{
+		"Line 1": "Trans 1",
+		"Line 2": "Trans 2",
+		"Line 3": "Trans 3",
+		"Line 4": "Trans 4",
+	}
+	opts := Options{
+		Checkpoint: true,
+		Settings:   RunSettings{Provider: "test", Model: "model", Prompt: "hash", TargetLang: "Spanish"},
+	}
+
+	failing := &mockTranslator{
+		translations: translations,
+		maxLength:    2,
+		failOn:       map[string]error{"Line 3": fmt.Errorf("translation service unavailable")},
+	}
+	err := TranslateFile(t.Context(), tmpInput, tmpOutput, failing, opts)
+	require.Error(t, err)
+	assert.FileExists(t, CheckpointPath(tmpOutput))
+
+	// the completed batch is not translated again
+	resumed := &mockTranslator{
+		translations: translations,
+		maxLength:    2,
+		failOn:       map[string]error{"Line 1": fmt.Errorf("translated again")},
+	}
+	opts.Resume = true
+	err = TranslateFile(t.Context(), tmpInput, tmpOutput, resumed, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, resumed.callCount)
+	assert.NoFileExists(t, CheckpointPath(tmpOutput))
+
+	outputContent, err := os.ReadFile(tmpOutput)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(outputContent))
+}
+
+func TestTranslateFileCheckpointMismatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpInput := filepath.Join(tmpDir, "input.srt")
+	tmpOutput := filepath.Join(tmpDir, "output.srt")
+	require.NoError(t, os.WriteFile(tmpInput, []byte(fourLinesSRT), 0644))
+
+	opts := Options{
+		Checkpoint: true,
+		Settings:   RunSettings{Provider: "test", Model: "model", Prompt: "hash", TargetLang: "Spanish"},
+	}
+	failing := &mockTranslator{
+		maxLength: 2,
+		failOn:    map[string]error{"Line 3": fmt.Errorf("translation service unavailable")},
+	}
+	require.Error(t, TranslateFile(t.Context(), tmpInput, tmpOutput, failing, opts))
+
+	t.Run("settings changed", func(t *testing.T) {
+		changed := opts
+		changed.Resume = true
+		changed.Settings.TargetLang = "French"
+		err := TranslateFile(t.Context(), tmpInput, tmpOutput, &mockTranslator{maxLength: 2}, changed)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "settings changed")
+	})
+
+	t.Run("input changed", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(tmpInput, []byte(fourLinesSRT+"\n"), 0644))
+		resume := opts
+		resume.Resume = true
+		err := TranslateFile(t.Context(), tmpInput, tmpOutput, &mockTranslator{maxLength: 2}, resume)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "input file changed")
+	})
+}
@@ -0,0 +1,118 @@
+package sub
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/asticode/go-astisub"
+)
+
+// Format is a subtitle file format.
+type Format string
+
+const (
+	FormatSRT    Format = "srt"
+	FormatWebVTT Format = "vtt"
+	FormatSSA    Format = "ssa"
+	FormatASS    Format = "ass"
+	FormatSTL    Format = "stl"
+	FormatTTML   Format = "ttml"
+)
+
+// ParseFormat returns the format called name, which is its file extension with or without the dot.
+func ParseFormat(name string) (Format, error) {
+	name = strings.TrimPrefix(strings.ToLower(name), ".")
+	switch f := Format(name); f {
+	case FormatSRT, FormatWebVTT, FormatSSA, FormatASS, FormatSTL, FormatTTML:
+		return f, nil
+	case "webvtt":
+		return FormatWebVTT, nil
+	}
+	return "", fmt.Errorf("unsupported subtitle format %q", name)
+}
+
+// FormatOf returns the format of path by its extension.
+func FormatOf(path string) (Format, error) {
+	ext := filepath.Ext(path)
+	if ext == "" {
+		return "", fmt.Errorf("no subtitle format in the extension of %s", path)
+	}
+	return ParseFormat(ext)
+}
+
+// isSSA reports whether the format has styled events, where each language of bilingual output is its own event.
+func (f Format) isSSA() bool {
+	return f == FormatSSA || f == FormatASS
+}
+
+// Read parses subtitles of format from r.
+func Read(r io.Reader, format Format) (*astisub.Subtitles, error) {
+	switch format {
+	case FormatSRT:
+		return astisub.ReadFromSRT(r)
+	case FormatWebVTT:
+		return astisub.ReadFromWebVTT(r)
+	case FormatSSA, FormatASS:
+		return astisub.ReadFromSSA(r)
+	case FormatSTL:
+		return astisub.ReadFromSTL(r, astisub.STLOptions{})
+	case FormatTTML:
+		return astisub.ReadFromTTML(r)
+	}
+	return nil, fmt.Errorf("unsupported subtitle format %q", format)
+}
+
+// Write writes subs to w in format.
+func Write(w io.Writer, subs *astisub.Subtitles, format Format) error {
+	switch format {
+	case FormatSRT:
+		return subs.WriteToSRT(w)
+	case FormatWebVTT:
+		return subs.WriteToWebVTT(w)
+	case FormatSSA, FormatASS:
+		return subs.WriteToSSA(w)
+	case FormatSTL:
+		return subs.WriteToSTL(w)
+	case FormatTTML:
+		return subs.WriteToTTML(w)
+	}
+	return fmt.Errorf("unsupported subtitle format %q", format)
+}
+
+// resolveFormat returns format, or the format of path if it is empty.
+func resolveFormat(format Format, path string) (Format, error) {
+	if format != "" {
+		return format, nil
+	}
+	return FormatOf(path)
+}
+
+// readFile parses the subtitles at path in format, empty format uses the extension of path.
+func readFile(path string, format Format) (*astisub.Subtitles, error) {
+	format, err := resolveFormat(format, path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f, format)
+}
+
+// writeFile writes subs to path in format.
+func writeFile(path string, subs *astisub.Subtitles, format Format) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, subs, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
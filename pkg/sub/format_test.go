@@ -0,0 +1,106 @@
+package sub
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const streamInput = `1
+00:00:01,000 --> 00:00:04,000
+Hello world
+
+2
+00:00:05,000 --> 00:00:08,000
+How are you?
+`
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Format
+		wantErr bool
+	}{
+		{"srt", FormatSRT, false},
+		{".SRT", FormatSRT, false},
+		{"vtt", FormatWebVTT, false},
+		{"webvtt", FormatWebVTT, false},
+		{"ass", FormatASS, false},
+		{"ssa", FormatSSA, false},
+		{"stl", FormatSTL, false},
+		{"ttml", FormatTTML, false},
+		{"sub", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFormat(tt.name)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	format, err := FormatOf("dir.v2/movie.en.vtt")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatWebVTT, format)
+	_, err = FormatOf("dir.v2/movie")
+	assert.ErrorContains(t, err, "no subtitle format")
+}
+
+func TestTranslateReader(t *testing.T) {
+	translator := &mockTranslator{
+		translations: map[string]string{"Hello world": "Hola mundo", "How are you?": "¿Cómo estás?"},
+		maxLength:    10,
+	}
+
+	var out bytes.Buffer
+	err := TranslateReader(t.Context(), strings.NewReader(streamInput), &out, translator, Options{InputFormat: FormatSRT, OutputFormat: FormatWebVTT})
+	require.NoError(t, err)
+	assert.Equal(t, "WEBVTT\n\n1\n00:00:01.000 --> 00:00:04.000\nHola mundo\n\n2\n00:00:05.000 --> 00:00:08.000\n¿Cómo estás?\n", out.String())
+
+	// the output format defaults to the input format
+	out.Reset()
+	err = TranslateReader(t.Context(), strings.NewReader(streamInput), &out, translator, Options{InputFormat: FormatSRT})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "00:00:01,000 --> 00:00:04,000\nHola mundo")
+
+	err = TranslateReader(t.Context(), strings.NewReader(streamInput), &out, translator, Options{})
+	assert.ErrorContains(t, err, "input format is required")
+}
+
+func TestTranslateReaderFails(t *testing.T) {
+	translator := &mockTranslator{maxLength: 1, failOn: map[string]error{"How are you?": assert.AnError}}
+
+	var out bytes.Buffer
+	err := TranslateReader(t.Context(), strings.NewReader(streamInput), &out, translator, Options{InputFormat: FormatSRT})
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Empty(t, out.String(), "no partial translation on a stream")
+}
+
+func TestTranslateFileFormats(t *testing.T) {
+	tmpDir := t.TempDir()
+	// extracted subtitles often lack a usable extension
+	input := filepath.Join(tmpDir, "track3")
+	output := filepath.Join(tmpDir, "track3.out")
+	require.NoError(t, os.WriteFile(input, []byte(streamInput), 0644))
+
+	translator := &mockTranslator{translations: map[string]string{"Hello world": "Hola mundo"}, maxLength: 10}
+	err := TranslateFile(t.Context(), input, output, translator, Options{InputFormat: FormatSRT, OutputFormat: FormatWebVTT})
+	require.NoError(t, err)
+	content, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "WEBVTT"))
+	assert.Contains(t, string(content), "Hola mundo")
+
+	err = TranslateFile(t.Context(), input, output, translator, Options{})
+	assert.ErrorContains(t, err, "no subtitle format")
+}
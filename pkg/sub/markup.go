@@ -0,0 +1,153 @@
+package sub
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/asticode/go-astisub"
+)
+
+// Unit is the part of a subtitle translated as one text.
+type Unit string
+
+const (
+	// UnitSegment translates every styled segment of a line on its own.
+	UnitSegment Unit = "segment"
+	// UnitLine translates a whole line, styled segments are marked up with <sN> tags.
+	UnitLine Unit = "line"
+	// UnitCue translates all lines of a cue together, lines are separated by newlines.
+	UnitCue Unit = "cue"
+	// UnitSentence translates consecutive cues forming one sentence together, cues are separated by CueSeparator.
+	UnitSentence Unit = "sentence"
+)
+
+// markupTagRegexp matches <sN>, </sN> and <sN/>, N is the 1-based number of the segment in its line or cue.
+var markupTagRegexp = regexp.MustCompile(`<(/?)s(\d+)(/?)>`)
+
+// isPlain reports whether item is text alone, without a style or a WebVTT timestamp tag, which astisub keeps as the
+// StartAt of the item.
+func isPlain(item astisub.LineItem) bool {
+	return item.InlineStyle == nil && item.Style == nil && item.StartAt == 0
+}
+
+// toMarkup renders items as one text, styled items are wrapped in <sN>...</sN> where N is first plus the
+// item index, styled items without text become <sN/>.
+func toMarkup(items []astisub.LineItem, first int) string {
+	var sb strings.Builder
+	for i, item := range items {
+		n := first + i
+		switch {
+		case isPlain(item):
+			sb.WriteString(item.Text)
+		case item.Text == "":
+			fmt.Fprintf(&sb, "<s%d/>", n)
+		default:
+			fmt.Fprintf(&sb, "<s%d>%s</s%d>", n, item.Text, n)
+		}
+	}
+	return sb.String()
+}
+
+// fromMarkup maps text rendered by toMarkup back to line items, tagged text copies the style of the
+// templates item with the same number, text outside of tags is plain.
+func fromMarkup(text string, templates []astisub.LineItem) []astisub.LineItem {
+	items := []astisub.LineItem{}
+	current := -1 // index in templates of the open tag, -1 outside of tags
+	emit := func(s string) {
+		if s == "" {
+			return
+		}
+		item := astisub.LineItem{Text: s}
+		if current >= 0 {
+			item = templates[current]
+			item.Text = s
+		}
+		items = append(items, item)
+	}
+
+	last := 0
+	for _, m := range markupTagRegexp.FindAllStringSubmatchIndex(text, -1) {
+		n, _ := strconv.Atoi(text[m[4]:m[5]])
+		index := n - 1
+		if index < 0 || index >= len(templates) {
+			// unknown tags are kept as text
+			continue
+		}
+		emit(text[last:m[0]])
+		last = m[1]
+
+		closing := m[3] > m[2]
+		selfClosing := m[7] > m[6]
+		switch {
+		case selfClosing:
+			item := templates[index]
+			item.Text = ""
+			items = append(items, item)
+		case closing:
+			current = -1
+		default:
+			current = index
+		}
+	}
+	emit(text[last:])
+	return items
+}
+
+func lineMarkup(line astisub.Line) string {
+	return toMarkup(line.Items, 1)
+}
+
+// cueMarkup renders all lines of item separated by newlines, segments are numbered across lines.
+func cueMarkup(item *astisub.Item) string {
+	text, _ := renderCue(item, 1)
+	return text
+}
+
+// renderCue renders item like cueMarkup with segments numbered from first, it returns the number after the last segment.
+func renderCue(item *astisub.Item, first int) (string, int) {
+	lines := make([]string, len(item.Lines))
+	for i, line := range item.Lines {
+		lines[i] = toMarkup(line.Items, first)
+		first += len(line.Items)
+	}
+	return strings.Join(lines, "\n"), first
+}
+
+// cueTemplates returns the line items of items in the order they are numbered by renderCue.
+func cueTemplates(items ...*astisub.Item) []astisub.LineItem {
+	templates := []astisub.LineItem{}
+	for _, item := range items {
+		for _, line := range item.Lines {
+			templates = append(templates, line.Items...)
+		}
+	}
+	return templates
+}
+
+// setCueMarkup replaces the lines of item with text rendered by cueMarkup, the number of lines may change.
+func setCueMarkup(item *astisub.Item, text string) {
+	setCueLines(item, text, cueTemplates(item))
+}
+
+func setCueLines(item *astisub.Item, text string, templates []astisub.LineItem) {
+	parts := strings.Split(text, "\n")
+	lines := make([]astisub.Line, len(parts))
+	for i, part := range parts {
+		if len(item.Lines) > 0 {
+			lines[i].VoiceName = item.Lines[min(i, len(item.Lines)-1)].VoiceName
+		}
+		lines[i].Items = fromMarkup(part, templates)
+	}
+	item.Lines = lines
+}
+
+func hasText(items []astisub.LineItem) bool {
+	for _, item := range items {
+		if strings.TrimSpace(item.Text) != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package sub
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkup(t *testing.T) {
+	italic := &astisub.StyleAttributes{SRTItalics: true}
+	bold := &astisub.StyleAttributes{SRTBold: true}
+	items := []astisub.LineItem{
+		{Text: "I "},
+		{Text: "really", InlineStyle: italic},
+		{Text: " mean "},
+		{Text: "", InlineStyle: bold},
+		{Text: "it"},
+	}
+
+	text := toMarkup(items, 1)
+	assert.Equal(t, "I <s2>really</s2> mean <s4/>it", text)
+
+	tests := []struct {
+		name string
+		text string
+		want []astisub.LineItem
+	}{
+		{
+			name: "reordered",
+			text: "Ich <s4/>meine es <s2>wirklich</s2>",
+			want: []astisub.LineItem{
+				{Text: "Ich "},
+				{Text: "", InlineStyle: bold},
+				{Text: "meine es "},
+				{Text: "wirklich", InlineStyle: italic},
+			},
+		},
+		{
+			name: "missing tags",
+			text: "Ich meine es wirklich",
+			want: []astisub.LineItem{{Text: "Ich meine es wirklich"}},
+		},
+		{
+			name: "unclosed tag",
+			text: "Ich <s2>meine es",
+			want: []astisub.LineItem{
+				{Text: "Ich "},
+				{Text: "meine es", InlineStyle: italic},
+			},
+		},
+		{
+			name: "unknown tag",
+			text: "Ich <s9>meine</s9> es",
+			want: []astisub.LineItem{{Text: "Ich <s9>meine</s9> es"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, fromMarkup(tt.text, items))
+		})
+	}
+}
+
+func TestCueMarkup(t *testing.T) {
+	italic := &astisub.StyleAttributes{SRTItalics: true}
+	item := &astisub.Item{Lines: []astisub.Line{
+		{VoiceName: "Bob", Items: []astisub.LineItem{{Text: "Hello"}}},
+		{VoiceName: "Bob", Items: []astisub.LineItem{{Text: "my "}, {Text: "friend", InlineStyle: italic}}},
+	}}
+
+	assert.Equal(t, "Hello\nmy <s3>friend</s3>", cueMarkup(item))
+
+	setCueMarkup(item, "Hallo <s3>Freund</s3>")
+	assert.Equal(t, []astisub.Line{
+		{VoiceName: "Bob", Items: []astisub.LineItem{{Text: "Hallo "}, {Text: "Freund", InlineStyle: italic}}},
+	}, item.Lines)
+}
+
+func TestTranslateFileUnit(t *testing.T) {
+	inputContent := `1
+00:00:01,000 --> 00:00:04,000
+I <i>really</i> mean it
+Second line
+
+2
+00:00:05,000 --> 00:00:08,000
+How are you?
+`
+
+	tests := []struct {
+		name         string
+		unit         Unit
+		translations map[string]string
+		wantTexts    []string
+		expected     string
+	}{
+		{
+			name: "line",
+			unit: UnitLine,
+			translations: map[string]string{
+				"I <s2>really</s2> mean it": "Ich meine es <s2>wirklich</s2>",
+				"Second line":               "Zweite Zeile",
+				"How are you?":              "Wie geht's?",
+			},
+			wantTexts: []string{"I <s2>really</s2> mean it", "Second line", "How are you?"},
+			expected: "\ufeff" + `1
+00:00:01,000 --> 00:00:04,000
+Ich meine es <i>wirklich</i>
+Zweite Zeile
+
+2
+00:00:05,000 --> 00:00:08,000
+Wie geht's?
+`,
+		},
+		{
+			name: "cue",
+			unit: UnitCue,
+			translations: map[string]string{
+				"I <s2>really</s2> mean it\nSecond line": "Ich meine es <s2>wirklich</s2>, zweite Zeile",
+				"How are you?":                           "Wie geht's?",
+			},
+			wantTexts: []string{"I <s2>really</s2> mean it\nSecond line", "How are you?"},
+			expected: "\ufeff" + `1
+00:00:01,000 --> 00:00:04,000
+Ich meine es <i>wirklich</i>, zweite Zeile
+
+2
+00:00:05,000 --> 00:00:08,000
+Wie geht's?
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			tmpInput := filepath.Join(tmpDir, "input.srt")
+			tmpOutput := filepath.Join(tmpDir, "output.srt")
+			require.NoError(t, os.WriteFile(tmpInput, []byte(inputContent), 0644))
+
+			translator := &mockTranslator{translations: tt.translations, maxLength: 10}
+			err := TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{Unit: tt.unit})
+			require.NoError(t, err)
+
+			require.Len(t, translator.batches, 1)
+			assert.Equal(t, tt.wantTexts, translator.batches[0].Texts)
+
+			outputContent, err := os.ReadFile(tmpOutput)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(outputContent))
+		})
+	}
+}
+
+func TestTranslateReaderUnitWebVTT(t *testing.T) {
+	input := `WEBVTT
+
+00:00:01.000 --> 00:00:04.000
+<v Bob><c.yellow>Hi</c> <00:00:01.500>there
+`
+	for _, unit := range []Unit{UnitLine, UnitCue, UnitSentence} {
+		t.Run(string(unit), func(t *testing.T) {
+			translator := &mockTranslator{
+				translations: map[string]string{"<s1>Hi</s1><s2>there</s2>": "<s1>Hallo</s1><s2>du</s2>"},
+				maxLength:    10,
+			}
+			var out bytes.Buffer
+			err := TranslateReader(t.Context(), strings.NewReader(input), &out, translator, Options{InputFormat: FormatWebVTT, Unit: unit})
+			require.NoError(t, err)
+
+			// the timestamp tag is a segment of its own, restored with its segment
+			require.Len(t, translator.batches, 1)
+			assert.Equal(t, []string{"<s1>Hi</s1><s2>there</s2>"}, translator.batches[0].Texts)
+			assert.Contains(t, out.String(), "<v Bob><c.yellow>Hallo</c><00:00:01.500>du\n")
+		})
+	}
+}
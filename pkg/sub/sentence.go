@@ -0,0 +1,160 @@
+package sub
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/asticode/go-astisub"
+)
+
+// CueSeparator separates the cues of a sentence translated with UnitSentence.
+const CueSeparator = "<cue/>"
+
+const (
+	defaultSentenceMaxGap  = time.Second
+	defaultSentenceMaxCues = 3
+)
+
+// Sentence controls how UnitSentence groups cues into sentences, zero values use the defaults.
+type Sentence struct {
+	// MaxGap is the longest pause between two cues of one sentence, defaults to 1s.
+	MaxGap time.Duration
+	// MaxCues is the largest number of cues in one sentence, defaults to 3.
+	MaxCues int
+}
+
+// groupSentences returns the first item and the number of items of every group of consecutive cues forming one
+// sentence, cues without text are skipped.
+func groupSentences(subs *astisub.Subtitles, rules Sentence) [][2]int {
+	maxGap := rules.MaxGap
+	if maxGap <= 0 {
+		maxGap = defaultSentenceMaxGap
+	}
+	maxCues := rules.MaxCues
+	if maxCues <= 0 {
+		maxCues = defaultSentenceMaxCues
+	}
+
+	groups := [][2]int{}
+	items := subs.Items
+	for i := 0; i < len(items); {
+		if plainText(items[i]) == "" {
+			i++
+			continue
+		}
+		n := 1
+		for n < maxCues && i+n < len(items) && continuesSentence(items[i+n-1], items[i+n], maxGap) {
+			n++
+		}
+		groups = append(groups, [2]int{i, n})
+		i += n
+	}
+	return groups
+}
+
+// continuesSentence reports whether next continues the sentence of prev.
+func continuesSentence(prev, next *astisub.Item, maxGap time.Duration) bool {
+	nextText := plainText(next)
+	if nextText == "" || next.StartAt-prev.EndAt > maxGap {
+		return false
+	}
+	if strings.HasPrefix(nextText, "-") || strings.HasPrefix(nextText, "–") || strings.HasPrefix(nextText, "—") {
+		// a new speaker in a dialogue
+		return false
+	}
+	return !endsSentence(plainText(prev))
+}
+
+func endsSentence(text string) bool {
+	text = strings.TrimRight(text, " \"'”’»)]」』")
+	r, _ := utf8.DecodeLastRuneInString(text)
+	return text == "" || strings.ContainsRune(".!?…。！？♪", r)
+}
+
+// plainText returns the text of item without styling, lines are joined by spaces.
+func plainText(item *astisub.Item) string {
+	lines := make([]string, 0, len(item.Lines))
+	for _, line := range item.Lines {
+		var sb strings.Builder
+		for _, seg := range line.Items {
+			sb.WriteString(seg.Text)
+		}
+		if text := strings.TrimSpace(sb.String()); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+// sentenceMarkup renders items like cueMarkup separated by CueSeparator, segments are numbered across all items.
+func sentenceMarkup(items []*astisub.Item) string {
+	parts := make([]string, len(items))
+	first := 1
+	for i, item := range items {
+		parts[i], first = renderCue(item, first)
+	}
+	return strings.Join(parts, "\n"+CueSeparator+"\n")
+}
+
+// setSentenceMarkup replaces the lines of items with text rendered by sentenceMarkup. If the translation does not
+// have a part for every cue, it is split in proportion to the length of the source cues.
+func setSentenceMarkup(items []*astisub.Item, text string) {
+	templates := cueTemplates(items...)
+	parts := strings.Split(text, CueSeparator)
+	if len(parts) != len(items) {
+		log.Printf("Warning: translation has %d parts for %d cues, splitting it by length: %q", len(parts), len(items), text)
+		weights := make([]int, len(items))
+		for i, item := range items {
+			weights[i] = max(utf8.RuneCountInString(plainText(item)), 1)
+		}
+		parts = splitByWeights(strings.Join(strings.Fields(strings.Join(parts, " ")), " "), weights)
+	}
+	for i, item := range items {
+		setCueLines(item, strings.TrimSpace(parts[i]), templates)
+	}
+}
+
+// splitByWeights splits text into len(weights) parts with lengths in proportion to weights, preferring to cut at
+// spaces and never cutting inside a markup tag.
+func splitByWeights(text string, weights []int) []string {
+	runes := []rune(text)
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+
+	parts := make([]string, len(weights))
+	start, sum := 0, 0
+	for i, w := range weights {
+		if i == len(weights)-1 {
+			parts[i] = string(runes[start:])
+			break
+		}
+		sum += w
+		cut := max(len(runes)*sum/total, start)
+		if space := slices.Index(runes[cut:], ' '); space >= 0 && space <= len(runes)/(2*len(weights)) {
+			// spaced languages are cut at the next word boundary when it is near
+			cut += space
+		}
+		if open := lastIndexRune(runes[start:cut], '<'); open >= 0 && lastIndexRune(runes[start:cut], '>') < open {
+			if end := slices.Index(runes[cut:], '>'); end >= 0 {
+				cut += end + 1
+			}
+		}
+		parts[i] = string(runes[start:cut])
+		start = cut
+	}
+	return parts
+}
+
+func lastIndexRune(runes []rune, r rune) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,168 @@
+package sub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupSentences(t *testing.T) {
+	cue := func(start, end time.Duration, text string) *astisub.Item {
+		return &astisub.Item{StartAt: start, EndAt: end, Lines: []astisub.Line{{Items: []astisub.LineItem{{Text: text}}}}}
+	}
+
+	tests := []struct {
+		name  string
+		items []*astisub.Item
+		rules Sentence
+		want  [][2]int
+	}{
+		{
+			name: "sentence over two cues",
+			items: []*astisub.Item{
+				cue(0, time.Second, "I told him that"),
+				cue(time.Second, 2*time.Second, "we were leaving."),
+				cue(2*time.Second, 3*time.Second, "Fine."),
+			},
+			want: [][2]int{{0, 2}, {2, 1}},
+		},
+		{
+			name: "gap too long",
+			items: []*astisub.Item{
+				cue(0, time.Second, "I told him that"),
+				cue(3*time.Second, 4*time.Second, "we were leaving."),
+			},
+			want: [][2]int{{0, 1}, {1, 1}},
+		},
+		{
+			name: "custom gap",
+			items: []*astisub.Item{
+				cue(0, time.Second, "I told him that"),
+				cue(3*time.Second, 4*time.Second, "we were leaving."),
+			},
+			rules: Sentence{MaxGap: 5 * time.Second},
+			want:  [][2]int{{0, 2}},
+		},
+		{
+			name: "closing quote and dialogue dash",
+			items: []*astisub.Item{
+				cue(0, time.Second, `He said "go."`),
+				cue(time.Second, 2*time.Second, "And then"),
+				cue(2*time.Second, 3*time.Second, "- Who?"),
+			},
+			want: [][2]int{{0, 1}, {1, 1}, {2, 1}},
+		},
+		{
+			name: "max cues",
+			items: []*astisub.Item{
+				cue(0, time.Second, "one"),
+				cue(time.Second, 2*time.Second, "two"),
+				cue(2*time.Second, 3*time.Second, "three"),
+			},
+			rules: Sentence{MaxCues: 2},
+			want:  [][2]int{{0, 2}, {2, 1}},
+		},
+		{
+			name: "empty cue",
+			items: []*astisub.Item{
+				cue(0, time.Second, "I told him that"),
+				cue(time.Second, 2*time.Second, ""),
+				cue(2*time.Second, 3*time.Second, "我们走吧。"),
+			},
+			want: [][2]int{{0, 1}, {2, 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, groupSentences(&astisub.Subtitles{Items: tt.items}, tt.rules))
+		})
+	}
+}
+
+func TestSplitByWeights(t *testing.T) {
+	assert.Equal(t, []string{"Ich sagte ihm,", " dass wir gehen."}, splitByWeights("Ich sagte ihm, dass wir gehen.", []int{15, 16}))
+	assert.Equal(t, []string{"我告诉他", "我们要走了"}, splitByWeights("我告诉他我们要走了", []int{4, 5}))
+	assert.Equal(t, []string{"ab<s1>", "cd</s1>"}, splitByWeights("ab<s1>cd</s1>", []int{1, 1}))
+}
+
+func TestTranslateFileSentence(t *testing.T) {
+	inputContent := `1
+00:00:01,000 --> 00:00:02,000
+I told him that
+
+2
+00:00:02,100 --> 00:00:04,000
+we were <i>leaving</i>.
+
+3
+00:00:05,000 --> 00:00:06,000
+Fine.
+`
+	sentence := "I told him that\n<cue/>\nwe were <s3>leaving</s3>."
+
+	tests := []struct {
+		name        string
+		translation string
+		expected    string
+	}{
+		{
+			name:        "parts for every cue",
+			translation: "Ich sagte ihm,\n<cue/>\ndass wir <s3>gehen</s3>.",
+			expected: "\ufeff" + `1
+00:00:01,000 --> 00:00:02,000
+Ich sagte ihm,
+
+2
+00:00:02,100 --> 00:00:04,000
+dass wir <i>gehen</i>.
+
+3
+00:00:05,000 --> 00:00:06,000
+Gut.
+`,
+		},
+		{
+			name:        "split by length",
+			translation: "Ich sagte ihm, dass wir <s3>gehen</s3>.",
+			expected: "\ufeff" + `1
+00:00:01,000 --> 00:00:02,000
+Ich sagte ihm, dass
+
+2
+00:00:02,100 --> 00:00:04,000
+wir <i>gehen</i>.
+
+3
+00:00:05,000 --> 00:00:06,000
+Gut.
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			tmpInput := filepath.Join(tmpDir, "input.srt")
+			tmpOutput := filepath.Join(tmpDir, "output.srt")
+			require.NoError(t, os.WriteFile(tmpInput, []byte(inputContent), 0644))
+
+			translator := &mockTranslator{
+				translations: map[string]string{sentence: tt.translation, "Fine.": "Gut."},
+				maxLength:    10,
+			}
+			err := TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{Unit: UnitSentence})
+			require.NoError(t, err)
+
+			require.Len(t, translator.batches, 1)
+			assert.Equal(t, []string{sentence, "Fine."}, translator.batches[0].Texts)
+
+			outputContent, err := os.ReadFile(tmpOutput)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(outputContent))
+		})
+	}
+}
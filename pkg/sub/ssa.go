@@ -0,0 +1,220 @@
+package sub
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/asticode/go-astisub"
+)
+
+// ssaLineBreak is the hard line break of ASS/SSA text, astisub splits lines at it.
+const ssaLineBreak = `\N`
+
+var (
+	// drawingRegexp matches the \pN tag of an override block starting the drawing mode, \p0 ends it.
+	drawingRegexp = regexp.MustCompile(`\\p[1-9]`)
+	// lineBreakRegexp matches a line break with the spaces around it, which the translation may add.
+	lineBreakRegexp = regexp.MustCompile(` *\\N *`)
+)
+
+// SSA selects the ASS/SSA events to translate, empty lists select all. Style names and actors match ignoring case.
+type SSA struct {
+	// IncludeStyles translates only the events of these styles.
+	IncludeStyles []string
+	// ExcludeStyles keeps the events of these styles untranslated, such as signs and songs.
+	ExcludeStyles []string
+	// IncludeActors translates only the events of these actors.
+	IncludeActors []string
+	// ExcludeActors keeps the events of these actors untranslated.
+	ExcludeActors []string
+}
+
+// includes reports whether the event of style and actor is translated.
+func (s SSA) includes(style, actor string) bool {
+	match := func(names []string, name string) bool {
+		return slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, name) })
+	}
+	if len(s.IncludeStyles) > 0 && !match(s.IncludeStyles, style) || match(s.ExcludeStyles, style) {
+		return false
+	}
+	return !(len(s.IncludeActors) > 0 && !match(s.IncludeActors, actor) || match(s.ExcludeActors, actor))
+}
+
+// ssaEvent keeps what prepareSSA hid of an event.
+type ssaEvent struct {
+	// skip is set for events kept untranslated: drawings, filtered events and events without text
+	skip bool
+	// drawing events are only written to ASS/SSA output
+	drawing bool
+	lines   []astisub.Line
+	// leading and trailing are the override blocks before and after the text, such as {\an8} or {\pos(...)}
+	leading  string
+	trailing string
+}
+
+// ssaEvents are the events of prepared subtitles, in the order of their items.
+type ssaEvents []ssaEvent
+
+// prepareSSA rewrites the events of subs for translation and returns what it needs to restore them. The lines of an
+// event, split at \N, become one line. Override blocks before and after the text are removed, blocks inside the text,
+// karaoke tags included, and the \N breaks become styled segments without text, which the markup of the line unit
+// shows as <sN/> tags. Skipped events have no lines, so they are not translated.
+func prepareSSA(subs *astisub.Subtitles, s SSA) ssaEvents {
+	events := make(ssaEvents, len(subs.Items))
+	for i, item := range subs.Items {
+		e := &events[i]
+		e.lines = item.Lines
+
+		style, actor := "", ""
+		if item.Style != nil {
+			style = item.Style.ID
+		}
+		if len(item.Lines) > 0 {
+			actor = item.Lines[0].VoiceName
+		}
+
+		// the parts of the event, blocks are items without text
+		var parts []astisub.LineItem
+		for j, line := range item.Lines {
+			if j > 0 {
+				// the space keeps the words apart for the model, it is removed again by restore
+				parts = append(parts, astisub.LineItem{Text: " "}, astisub.LineItem{InlineStyle: &astisub.StyleAttributes{SSAEffect: ssaLineBreak}})
+			}
+			for _, seg := range line.Items {
+				if seg.InlineStyle != nil && seg.InlineStyle.SSAEffect != "" {
+					parts = append(parts, astisub.LineItem{InlineStyle: &astisub.StyleAttributes{SSAEffect: seg.InlineStyle.SSAEffect}})
+				}
+				if seg.Text != "" {
+					parts = append(parts, astisub.LineItem{Text: seg.Text})
+				}
+			}
+		}
+
+		first := slices.IndexFunc(parts, func(p astisub.LineItem) bool { return strings.TrimSpace(p.Text) != "" })
+		drawing := slices.ContainsFunc(parts, func(p astisub.LineItem) bool {
+			return p.InlineStyle != nil && drawingRegexp.MatchString(p.InlineStyle.SSAEffect)
+		})
+		if first < 0 || drawing || !s.includes(style, actor) {
+			e.skip = true
+			e.drawing = drawing
+			item.Lines = nil
+			continue
+		}
+		last := len(parts) - 1
+		for strings.TrimSpace(parts[last].Text) == "" {
+			last--
+		}
+
+		line := astisub.Line{VoiceName: actor}
+		for j, p := range parts {
+			if p.InlineStyle == nil {
+				text := p.Text
+				if j == first {
+					text = strings.TrimLeft(text, " ")
+				}
+				if j == last {
+					text = strings.TrimRight(text, " ")
+				}
+				if j >= first && j <= last {
+					line.Items = appendText(line.Items, text)
+				}
+				continue
+			}
+			block := p.InlineStyle.SSAEffect
+			switch {
+			case j < first:
+				e.leading += block
+			case j > last:
+				e.trailing += block
+			default:
+				line.Items = append(line.Items, astisub.LineItem{InlineStyle: &astisub.StyleAttributes{SSAEffect: block}})
+			}
+		}
+		item.Lines = []astisub.Line{line}
+	}
+	return events
+}
+
+// appendText appends text to items, joined with the last item if it is plain.
+func appendText(items []astisub.LineItem, text string) []astisub.LineItem {
+	if n := len(items); n > 0 && isPlain(items[n-1]) {
+		items[n-1].Text += text
+		return items
+	}
+	return append(items, astisub.LineItem{Text: text})
+}
+
+// restore returns a copy of subs with the events prepared by prepareSSA written back. For ASS/SSA output an event is
+// one line of text with its override blocks, the lines of a translation are joined with \N. Other formats get the
+// text alone, broken into lines at \N.
+func (events ssaEvents) restore(subs *astisub.Subtitles, ssa bool) *astisub.Subtitles {
+	subs = cloneSubtitles(subs)
+	for i, item := range subs.Items {
+		e := events[i]
+		lines := item.Lines
+		if e.skip {
+			lines = e.lines
+		}
+		actor := ""
+		if len(e.lines) > 0 {
+			actor = e.lines[0].VoiceName
+		}
+
+		if !ssa {
+			item.Lines = nil
+			text := strings.Join(renderSSA(lines, false), ssaLineBreak)
+			for line := range strings.SplitSeq(lineBreakRegexp.ReplaceAllString(text, ssaLineBreak), ssaLineBreak) {
+				item.Lines = append(item.Lines, astisub.Line{VoiceName: actor, Items: []astisub.LineItem{{Text: line}}})
+			}
+			continue
+		}
+		// the event is written as a single segment, astisub would put spaces between segments
+		text := strings.Join(renderSSA(lines, true), ssaLineBreak)
+		if !e.skip {
+			text = e.leading + text + e.trailing
+		}
+		text = lineBreakRegexp.ReplaceAllString(text, ssaLineBreak)
+		item.Lines = []astisub.Line{{VoiceName: actor, Items: []astisub.LineItem{{Text: text}}}}
+	}
+	return subs
+}
+
+// skipped reports for every event whether it is kept untranslated.
+func (events ssaEvents) skipped() []bool {
+	skipped := make([]bool, len(events))
+	for i, e := range events {
+		skipped[i] = e.skip
+	}
+	return skipped
+}
+
+// dropDrawings removes the drawing events from subs restored for other formats than ASS/SSA, where they would be
+// written as text.
+func (events ssaEvents) dropDrawings(subs *astisub.Subtitles) *astisub.Subtitles {
+	clone := *subs
+	clone.Items = nil
+	for i, item := range subs.Items {
+		if !events[i].drawing {
+			clone.Items = append(clone.Items, item)
+		}
+	}
+	return &clone
+}
+
+// renderSSA returns the text of every line, with the override blocks of its segments if blocks is set. Line breaks
+// are always kept.
+func renderSSA(lines []astisub.Line, blocks bool) []string {
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		var sb strings.Builder
+		for _, seg := range line.Items {
+			if seg.InlineStyle != nil && (blocks || seg.InlineStyle.SSAEffect == ssaLineBreak) {
+				sb.WriteString(seg.InlineStyle.SSAEffect)
+			}
+			sb.WriteString(seg.Text)
+		}
+		texts[i] = sb.String()
+	}
+	return texts
+}
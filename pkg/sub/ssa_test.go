@@ -0,0 +1,172 @@
+package sub
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const ssaInput = `[Script Info]
+ScriptType: v4.00+
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, Alignment
+Style: Default,Arial,40,&H00FFFFFF,2
+Style: Signs,Arial,30,&H00FFFFFF,8
+Style: Song,Arial,30,&H00FFFFFF,8
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:01.00,0:00:04.00,Default,Bob,0,0,0,,{\an8}I {\i1}really{\i0} mean it\Nthis time.
+Dialogue: 0,0:00:05.00,0:00:08.00,Signs,,0,0,0,,{\pos(320,50)}Bakery
+Dialogue: 0,0:00:05.00,0:00:08.00,Default,,0,0,0,,{\p1}m 0 0 l 100 0 100 100{\p0}
+Dialogue: 0,0:00:09.00,0:00:12.00,Song,,0,0,0,,{\k20}Ka{\k30}ra{\k40}oke{\fad(200,0)}
+Dialogue: 0,0:00:13.00,0:00:16.00,Default,Alice,0,0,0,,Goodbye
+`
+
+func TestSSAIncludes(t *testing.T) {
+	tests := []struct {
+		name  string
+		ssa   SSA
+		style string
+		actor string
+		want  bool
+	}{
+		{"no filter", SSA{}, "Default", "Bob", true},
+		{"included style", SSA{IncludeStyles: []string{"default"}}, "Default", "", true},
+		{"not included style", SSA{IncludeStyles: []string{"Default"}}, "Signs", "", false},
+		{"excluded style", SSA{ExcludeStyles: []string{"Signs", "Song"}}, "Song", "", false},
+		{"included actor", SSA{IncludeActors: []string{"Bob"}}, "Default", "Bob", true},
+		{"not included actor", SSA{IncludeActors: []string{"Bob"}}, "Default", "", false},
+		{"excluded actor", SSA{ExcludeActors: []string{"Bob"}}, "Default", "Bob", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.ssa.includes(tt.style, tt.actor))
+		})
+	}
+}
+
+func TestPrepareSSA(t *testing.T) {
+	subs, err := Read(strings.NewReader(ssaInput), FormatASS)
+	require.NoError(t, err)
+
+	events := prepareSSA(subs, SSA{ExcludeActors: []string{"Alice"}})
+	texts := make([]string, len(subs.Items))
+	for i, item := range subs.Items {
+		texts[i] = cueMarkup(item)
+	}
+	assert.Equal(t, []string{
+		"I <s2/>really<s4/> mean it <s6/>this time.",
+		"Bakery",
+		"", // drawing
+		"Ka<s2/>ra<s4/>oke",
+		"", // excluded actor
+	}, texts)
+	assert.Equal(t, []bool{false, false, true, false, true},
+		[]bool{events[0].skip, events[1].skip, events[2].skip, events[3].skip, events[4].skip})
+	assert.Equal(t, `{\an8}`, events[0].leading)
+	assert.Equal(t, `{\k20}`, events[3].leading)
+	assert.Equal(t, `{\fad(200,0)}`, events[3].trailing)
+	assert.True(t, events[2].drawing)
+
+	setCueMarkup(subs.Items[0], "Ich <s2/>meine<s4/> es <s6/> diesmal\nernst.")
+	restored := events.restore(subs, true)
+	assert.Equal(t, `{\an8}Ich {\i1}meine{\i0} es\Ndiesmal\Nernst.`, restored.Items[0].Lines[0].Items[0].Text)
+	assert.Equal(t, "Bob", restored.Items[0].Lines[0].VoiceName)
+	assert.Equal(t, `{\pos(320,50)}Bakery`, restored.Items[1].Lines[0].Items[0].Text)
+	assert.Len(t, subs.Items[2].Lines, 0, "restore works on a copy")
+
+	plain := events.restore(subs, false)
+	require.Len(t, plain.Items[0].Lines, 3)
+	assert.Equal(t, "Ich meine es", plain.Items[0].Lines[0].Items[0].Text)
+	assert.Equal(t, "diesmal", plain.Items[0].Lines[1].Items[0].Text)
+	assert.Equal(t, "Goodbye", plain.Items[4].Lines[0].Items[0].Text)
+
+	plain = events.dropDrawings(plain)
+	require.Len(t, plain.Items, 4)
+	assert.Equal(t, "Karaoke", plain.Items[2].Lines[0].Items[0].Text)
+}
+
+func TestTranslateReaderSSA(t *testing.T) {
+	translator := &mockTranslator{
+		translations: map[string]string{
+			"I <s2/>really<s4/> mean it <s6/>this time.": "Diesmal meine ich es <s2/>wirklich<s4/> <s6/>ernst.",
+			"Goodbye": "Tschüss",
+		},
+		maxLength: 10,
+	}
+
+	var out bytes.Buffer
+	err := TranslateReader(t.Context(), strings.NewReader(ssaInput), &out, translator, Options{
+		InputFormat: FormatASS,
+		SSA:         SSA{ExcludeStyles: []string{"Signs", "Song"}},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, translator.batches, 1)
+	assert.Equal(t, []string{"I <s2/>really<s4/> mean it <s6/>this time.", "Goodbye"}, translator.batches[0].Texts)
+	assert.Contains(t, out.String(), `Default,Bob,0,0,0,,{\an8}Diesmal meine ich es {\i1}wirklich{\i0}\Nernst.`+"\n")
+	// skipped events are written as they were read
+	assert.Contains(t, out.String(), `Signs,,0,0,0,,{\pos(320,50)}Bakery`+"\n")
+	assert.Contains(t, out.String(), `{\p1}m 0 0 l 100 0 100 100{\p0}`)
+	assert.Contains(t, out.String(), `Song,,0,0,0,,{\k20}Ka{\k30}ra{\k40}oke{\fad(200,0)}`+"\n")
+	assert.Contains(t, out.String(), `Default,Alice,0,0,0,,Tschüss`+"\n")
+}
+
+func TestTranslateReaderSSARoundTrip(t *testing.T) {
+	// the translator echoes the texts, so the events must come back as they were read
+	var out bytes.Buffer
+	err := TranslateReader(t.Context(), strings.NewReader(ssaInput), &out, &mockTranslator{maxLength: 10}, Options{
+		InputFormat: FormatASS,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), `Default,Bob,0,0,0,,{\an8}I {\i1}really{\i0} mean it\Nthis time.`+"\n")
+	assert.Contains(t, out.String(), `Song,,0,0,0,,{\k20}Ka{\k30}ra{\k40}oke{\fad(200,0)}`+"\n")
+
+	out.Reset()
+	err = TranslateReader(t.Context(), strings.NewReader(ssaInput), &out, &mockTranslator{maxLength: 10}, Options{
+		InputFormat:  FormatASS,
+		OutputFormat: FormatSRT,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "I really mean it\nthis time.\n")
+	assert.Contains(t, out.String(), "Karaoke\n")
+	assert.NotContains(t, out.String(), "m 0 0 l", "drawings are left out of SRT")
+}
+
+func TestTranslateReaderSSABilingual(t *testing.T) {
+	translations := map[string]string{
+		"I <s2/>really<s4/> mean it <s6/>this time.": "Diesmal meine ich es <s2/>wirklich<s4/> <s6/>ernst.",
+		"Goodbye": "Tschüss",
+	}
+	opts := Options{
+		InputFormat: FormatASS,
+		SSA:         SSA{ExcludeStyles: []string{"Signs", "Song"}},
+		Bilingual:   Bilingual{Enabled: true},
+	}
+
+	var out bytes.Buffer
+	err := TranslateReader(t.Context(), strings.NewReader(ssaInput), &out, &mockTranslator{translations: translations, maxLength: 10}, opts)
+	require.NoError(t, err)
+	// the source event is restored like the translation
+	assert.Contains(t, out.String(), `Default,Bob,0,0,0,,{\an8}I {\i1}really{\i0} mean it\Nthis time.`+"\n")
+	assert.Contains(t, out.String(), `Default Secondary,Bob,0,0,0,,{\an8}Diesmal meine ich es {\i1}wirklich{\i0}\Nernst.`+"\n")
+	// untranslated events are written once
+	assert.Equal(t, 1, strings.Count(out.String(), `{\pos(320,50)}Bakery`))
+	assert.Equal(t, 1, strings.Count(out.String(), `{\p1}m 0 0 l 100 0 100 100{\p0}`))
+	assert.Equal(t, 1, strings.Count(out.String(), `{\k20}Ka{\k30}ra{\k40}oke{\fad(200,0)}`))
+	assert.NotContains(t, out.String(), "Signs Secondary")
+
+	out.Reset()
+	opts.OutputFormat = FormatSRT
+	err = TranslateReader(t.Context(), strings.NewReader(ssaInput), &out, &mockTranslator{translations: translations, maxLength: 10}, opts)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "I really mean it\nthis time.\nDiesmal meine ich es wirklich\nernst.\n")
+	assert.Contains(t, out.String(), "\nBakery\n\n")
+	assert.Contains(t, out.String(), "\nKaraoke\n\n")
+	assert.Contains(t, out.String(), "\nGoodbye\nTschüss\n")
+}
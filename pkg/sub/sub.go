@@ -1,14 +1,27 @@
 package sub
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"slices"
+	"sync"
 
 	"github.com/asticode/go-astisub"
 )
 
 const maxItemPerBatch = 10
 
+var (
+	// ErrCountMismatch is returned by a Translator when the number of translations differs from the number of texts.
+	ErrCountMismatch = errors.New("translation count mismatch")
+	// ErrMalformedResponse is returned by a Translator when the response can not be parsed.
+	ErrMalformedResponse = errors.New("malformed translation response")
+)
+
 type TranslationError struct {
 	BatchNumber    int
 	CompletedItems int
@@ -25,10 +38,58 @@ func (e *TranslationError) Unwrap() error {
 	return e.Err
 }
 
+// ResumeIndex returns the item, line and seg index to resume the translation from.
+func (e *TranslationError) ResumeIndex() (int, int, int) {
+	return e.FirstFailed.itemIndex, e.FirstFailed.lineIndex, e.FirstFailed.segIndex
+}
+
 type Translator interface {
-	Translate(texts []string) ([]string, error)
+	Translate(ctx context.Context, batch Batch) ([]string, error)
+	// Length is the length text adds to a request.
 	Length(text string) int
+	// MaxLength is the limit of the texts and context lines of a request.
 	MaxLength() int
+	// OutputLength is the expected length the translation of text adds to the response.
+	OutputLength(text string) int
+	// MaxOutputLength is the limit of the translations of a response, 0 means no limit.
+	MaxOutputLength() int
+}
+
+// Batch is a group of texts translated in one request.
+type Batch struct {
+	// Texts must all be translated and returned in the same order.
+	Texts []string
+	// Before are the lines preceding Texts, they are read-only context.
+	Before []ContextLine
+	// After are the source lines following Texts, they are read-only context.
+	After []string
+}
+
+// BatchInfo identifies the batch a translation request is made for, translators read it from the request context
+// with BatchInfoFrom, e.g. to report requests per batch. Retries and split halves of a batch share its info.
+type BatchInfo struct {
+	Input      string // path of the input file, empty when reading a stream
+	TargetLang string
+	Batch      int // 1-based number of the batch in the file
+}
+
+type batchInfoKey struct{}
+
+// WithBatchInfo returns ctx translating the batch of info.
+func WithBatchInfo(ctx context.Context, info BatchInfo) context.Context {
+	return context.WithValue(ctx, batchInfoKey{}, info)
+}
+
+// BatchInfoFrom returns the info of the batch ctx translates.
+func BatchInfoFrom(ctx context.Context) (BatchInfo, bool) {
+	info, ok := ctx.Value(batchInfoKey{}).(BatchInfo)
+	return info, ok
+}
+
+// ContextLine is a source line with its translation, Translation is empty if it is not translated yet.
+type ContextLine struct {
+	Text        string `json:"text"`
+	Translation string `json:"translation,omitempty"`
 }
 
 type textInfo struct {
@@ -37,23 +98,59 @@ type textInfo struct {
 	segIndex  int
 	length    int
 	text      string
+	// outputLength is the expected length of the translation
+	outputLength int
+	// cues is the number of items of a sentence starting at itemIndex, only set for UnitSentence
+	cues int
 }
 
-func extractInfos(subs *astisub.Subtitles, translator Translator) []textInfo {
+func extractInfos(subs *astisub.Subtitles, translator Translator, opts Options) []textInfo {
 	infos := []textInfo{}
+	if opts.Unit == UnitSentence {
+		for _, group := range groupSentences(subs, opts.Sentence) {
+			items := subs.Items[group[0] : group[0]+group[1]]
+			text := sentenceMarkup(items)
+			infos = append(infos, textInfo{
+				itemIndex:    group[0],
+				text:         text,
+				length:       translator.Length(text),
+				outputLength: translator.OutputLength(text),
+				cues:         group[1],
+			})
+		}
+		return infos
+	}
+
+	unit := opts.Unit
+	add := func(itemIndex, lineIndex, segIndex int, text string) {
+		infos = append(infos, textInfo{
+			itemIndex:    itemIndex,
+			lineIndex:    lineIndex,
+			segIndex:     segIndex,
+			text:         text,
+			length:       translator.Length(text),
+			outputLength: translator.OutputLength(text),
+		})
+	}
 	for itemIndex, item := range subs.Items {
+		if unit == UnitCue {
+			if slices.ContainsFunc(item.Lines, func(line astisub.Line) bool { return hasText(line.Items) }) {
+				add(itemIndex, 0, 0, cueMarkup(item))
+			}
+			continue
+		}
 		for lineIndex, line := range item.Lines {
+			if unit == UnitLine {
+				if hasText(line.Items) {
+					add(itemIndex, lineIndex, 0, lineMarkup(line))
+				}
+				continue
+			}
 			for segIndex, seg := range line.Items {
 				if seg.Text == "" {
 					continue
 				}
-				infos = append(infos, textInfo{
-					itemIndex: itemIndex,
-					lineIndex: lineIndex,
-					segIndex:  segIndex,
-					text:      seg.Text,
-					length:    translator.Length(seg.Text),
-				})
+				add(itemIndex, lineIndex, segIndex, seg.Text)
 			}
 		}
 	}
@@ -69,64 +166,334 @@ func findOffset(infos []textInfo, fromItem, fromLine, fromSeg int) (int, error)
 	return 0, fmt.Errorf("specified index %d,%d,%d not found in input file", fromItem, fromLine, fromSeg)
 }
 
-func processBatches(subs *astisub.Subtitles, infos []textInfo, startingOffset int, globalCompleted int, translator Translator, outputPath string, partialLogMsg string) error {
-	infosToProcess := infos[startingOffset:]
-	batches := createBatches(infosToProcess, translator.MaxLength())
+// Options controls how a subtitle file is translated.
+type Options struct {
+	// Concurrency is the number of batches translated in parallel, values below 1 mean 1.
+	Concurrency int
+	// Checkpoint saves completed translations to a sidecar file next to the output after every batch.
+	Checkpoint bool
+	// Resume continues from the checkpoint sidecar file if there is one.
+	Resume bool
+	// Settings are recorded in the checkpoint, resuming refuses a checkpoint with different settings.
+	Settings RunSettings
+	// ContextBefore is the number of preceding lines sent with each batch as read-only context.
+	ContextBefore int
+	// ContextAfter is the number of following lines sent with each batch as read-only context.
+	ContextAfter int
+	// Bilingual writes the source text together with the translation.
+	Bilingual Bilingual
+	// Unit is the part of a subtitle translated as one text, empty means UnitSegment.
+	Unit Unit
+	// Sentence controls how cues are grouped into sentences for UnitSentence.
+	Sentence Sentence
+	// InputFormat is the format of the input, empty means the format of the extension of the input path.
+	InputFormat Format
+	// OutputFormat is the format of the output, empty means the format of the extension of the output path, or the
+	// input format when writing to a stream.
+	OutputFormat Format
+	// SSA selects the events of ASS/SSA input to translate.
+	SSA SSA
+}
+
+// processBatches translates infos[i] for every i in pending, the others are already translated in subs.
+func processBatches(ctx context.Context, subs *astisub.Subtitles, infos []textInfo, pending []int, translator Translator, out output, partialLogMsg string, opts Options, cp *checkpoint) error {
+	batches := createBatches(infos, pending, translator.MaxLength(), translator.MaxOutputLength(), opts)
+	concurrency := max(opts.Concurrency, 1)
+	completed := len(infos) - len(pending)
 
-	log.Printf("total batches %d, limit length %d", len(batches), translator.MaxLength())
-	currentOffset := 0
-	for i, batch := range batches {
-		log.Printf("Translating batch %d (items %d, length %d)", i+1, len(batch), getBatchLength(batch))
-		translations, err := translator.Translate(batch)
-		if err != nil {
-			if currentOffset > 0 {
-				writeErr := subs.Write(outputPath)
-				if writeErr != nil {
-					log.Printf("Warning: failed to write partial translation: %v", writeErr)
+	// offsets[i] is the position of batches[i] in pending
+	offsets := make([]int, len(batches))
+	for i := 1; i < len(batches); i++ {
+		offsets[i] = offsets[i-1] + len(batches[i-1])
+	}
+
+	// translated[i] reports whether subs holds the translation of infos[i]
+	translated := make([]bool, len(infos))
+	for i := range translated {
+		translated[i] = true
+	}
+	for _, i := range pending {
+		translated[i] = false
+	}
+
+	log.Printf("total batches %d, limit length %d, concurrency %d", len(batches), translator.MaxLength(), concurrency)
+
+	var (
+		mu     sync.Mutex
+		failed bool
+		wg     sync.WaitGroup
+	)
+	errs := make([]error, len(batches))
+	done := make([]bool, len(batches))
+	jobs := make(chan int)
+	for range concurrency {
+		wg.Go(func() {
+			for i := range jobs {
+				mu.Lock()
+				batch := buildBatch(subs, infos, batches[i], translated, opts)
+				mu.Unlock()
+
+				log.Printf("Translating batch %d (items %d, length %d)", i+1, len(batch.Texts), getBatchLength(batch.Texts))
+				info, _ := BatchInfoFrom(ctx)
+				info.Batch = i + 1
+				translations, err := translateOrSplit(WithBatchInfo(ctx, info), translator, batch)
+
+				mu.Lock()
+				if err != nil {
+					errs[i] = err
+					failed = true
 				} else {
-					log.Printf(partialLogMsg, currentOffset)
+					for j, translation := range translations {
+						index := batches[i][j]
+						setText(subs, infos[index], translation, opts.Unit)
+						translated[index] = true
+						if cp != nil {
+							cp.add(infos[index], translation)
+						}
+					}
+					done[i] = true
+					if cp != nil {
+						if err := cp.save(); err != nil {
+							log.Printf("Warning: failed to save checkpoint: %v", err)
+						}
+					}
 				}
+				mu.Unlock()
 			}
-			return &TranslationError{
-				BatchNumber:    i + 1,
-				CompletedItems: globalCompleted + currentOffset,
-				FirstFailed:    infos[startingOffset+currentOffset],
-				Err:            err,
+		})
+	}
+
+	// Batches are dispatched in order and dispatching stops at the first failure or cancellation,
+	// so every batch before the earliest unfinished one has completed and resuming from it loses no work.
+dispatch:
+	for i := range batches {
+		mu.Lock()
+		stop := failed
+		mu.Unlock()
+		if stop {
+			break
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			log.Printf("Translation cancelled, waiting for in-flight batches")
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i := range batches {
+		if done[i] {
+			continue
+		}
+		err := errs[i]
+		if err == nil {
+			// never dispatched
+			err = ctx.Err()
+		}
+		currentOffset := offsets[i]
+		if out.w == nil && (currentOffset > 0 || (cp != nil && len(cp.Completed) > 0)) {
+			writeErr := out.write(subs)
+			if writeErr != nil {
+				log.Printf("Warning: failed to write partial translation: %v", writeErr)
+			} else {
+				log.Printf(partialLogMsg, currentOffset)
 			}
 		}
-		for j := 0; j < len(batch); j++ {
-			info := infosToProcess[currentOffset+j]
-			subs.Items[info.itemIndex].Lines[info.lineIndex].Items[info.segIndex].Text = translations[j]
+		return &TranslationError{
+			BatchNumber:    i + 1,
+			CompletedItems: completed + currentOffset,
+			FirstFailed:    infos[pending[currentOffset]],
+			Err:            err,
+		}
+	}
+	log.Printf("Translation completed: %d items translated", len(pending))
+
+	if err := out.write(subs); err != nil {
+		return err
+	}
+	if cp != nil {
+		return cp.remove()
+	}
+	return nil
+}
+
+func TranslateFile(ctx context.Context, inputPath, outputPath string, translator Translator, opts Options) error {
+	return TranslateFileTargets(ctx, inputPath, []Target{{OutputPath: outputPath, Translator: translator}}, opts)
+}
+
+// Target is one of the languages a file is translated to.
+type Target struct {
+	// Lang replaces the target language of Options.Settings in the checkpoint if it is set.
+	Lang       string
+	OutputPath string
+	Translator Translator
+}
+
+// TranslateFileTargets translates inputPath to every target one after another. The file is parsed and split into
+// texts once, with the lengths of the first translator, so all targets share the same batches. A failed target does
+// not stop the others, their errors are joined.
+func TranslateFileTargets(ctx context.Context, inputPath string, targets []Target, opts Options) error {
+	format, err := resolveFormat(opts.InputFormat, inputPath)
+	if err != nil {
+		return err
+	}
+	source, err := readFile(inputPath, format)
+	if err != nil {
+		return err
+	}
+	in := prepare(inputPath, source, format, &opts)
+	in.infos = extractInfos(in.subs, targets[0].Translator, opts)
+
+	if len(targets) == 1 {
+		return translateTarget(ctx, in, targets[0], opts)
+	}
+	var errs []error
+	for _, target := range targets {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", target.OutputPath, err))
+			continue
+		}
+		log.Printf("Translating to %s", target.OutputPath)
+		if err := translateTarget(ctx, in, target, opts); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", target.OutputPath, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// input is a parsed subtitle file ready to be translated.
+type input struct {
+	path string
+	// subs are the subtitles the texts are extracted from, translations are written to copies of them, so they stay
+	// untranslated for bilingual output
+	subs *astisub.Subtitles
+	// ssa restores the events of ASS/SSA input, nil for other formats
+	ssa   ssaEvents
+	infos []textInfo
+}
+
+// prepare readies source of format for translation. The events of ASS/SSA input are rewritten by prepareSSA, each
+// is one line and translated as one text, so the segment unit becomes the line unit in opts.
+func prepare(path string, source *astisub.Subtitles, format Format, opts *Options) input {
+	in := input{path: path, subs: source}
+	if !format.isSSA() {
+		return in
+	}
+	in.subs = cloneSubtitles(source)
+	in.ssa = prepareSSA(in.subs, opts.SSA)
+	if opts.Unit == "" || opts.Unit == UnitSegment {
+		// override blocks split the segments of an event, they are no units of meaning
+		opts.Unit = UnitLine
+	}
+	return in
+}
+
+// translateTarget translates a copy of the subtitles of in.
+func translateTarget(ctx context.Context, in input, target Target, opts Options) error {
+	if target.Lang != "" {
+		opts.Settings.TargetLang = target.Lang
+	}
+	subs := cloneSubtitles(in.subs)
+	infos := in.infos
+	pending := make([]int, len(infos))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	var cp *checkpoint
+	if opts.Checkpoint {
+		var err error
+		cp, err = openCheckpoint(in.path, target.OutputPath, opts)
+		if err != nil {
+			return err
 		}
-		currentOffset += len(batch)
+		pending = cp.apply(subs, infos, opts.Unit)
 	}
-	log.Printf("Translation completed: %d items translated", len(infosToProcess))
 
-	return subs.Write(outputPath)
+	format, err := resolveFormat(opts.OutputFormat, target.OutputPath)
+	if err != nil {
+		return err
+	}
+	out := output{path: target.OutputPath, format: format, ssa: in.ssa, bilingual: opts.Bilingual}
+	if opts.Bilingual.Enabled {
+		out.source = in.subs
+	}
+
+	ctx = WithBatchInfo(ctx, BatchInfo{Input: in.path, TargetLang: opts.Settings.TargetLang})
+	return processBatches(ctx, subs, infos, pending, target.Translator, out, "Wrote partial translation with %d completed items", opts, cp)
 }
 
-func TranslateFile(inputPath, outputPath string, translator Translator) error {
-	subs, err := astisub.OpenFile(inputPath)
+// TranslateReader translates the subtitles of opts.InputFormat read from r and writes them to w once all are
+// translated, nothing is written if the translation fails. Without files there is no checkpoint to resume from.
+func TranslateReader(ctx context.Context, r io.Reader, w io.Writer, translator Translator, opts Options) error {
+	if opts.InputFormat == "" {
+		return errors.New("input format is required to read subtitles from a stream")
+	}
+	source, err := Read(r, opts.InputFormat)
 	if err != nil {
 		return err
 	}
+	in := prepare("", source, opts.InputFormat, &opts)
+	infos := extractInfos(in.subs, translator, opts)
+	pending := make([]int, len(infos))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	out := output{w: w, format: cmp.Or(opts.OutputFormat, opts.InputFormat), ssa: in.ssa, bilingual: opts.Bilingual}
+	if opts.Bilingual.Enabled {
+		out.source = in.subs
+	}
+	ctx = WithBatchInfo(ctx, BatchInfo{TargetLang: opts.Settings.TargetLang})
+	return processBatches(ctx, cloneSubtitles(in.subs), infos, pending, translator, out, "", opts, nil)
+}
 
-	infos := extractInfos(subs, translator)
-	return processBatches(subs, infos, 0, 0, translator, outputPath, "Wrote partial translation with %d completed items")
+// cloneSubtitles copies the items of subs down to the line items translations are written to, styles and other
+// attributes are shared.
+func cloneSubtitles(subs *astisub.Subtitles) *astisub.Subtitles {
+	clone := *subs
+	clone.Items = make([]*astisub.Item, len(subs.Items))
+	for i, item := range subs.Items {
+		c := *item
+		c.Lines = slices.Clone(item.Lines)
+		for j := range c.Lines {
+			c.Lines[j].Items = slices.Clone(c.Lines[j].Items)
+		}
+		clone.Items[i] = &c
+	}
+	return &clone
 }
 
-func TranslateFileFromIndex(inputPath, outputPath string, translator Translator, fromItem, fromLine, fromSeg int) error {
-	subs, err := astisub.OpenFile(outputPath)
+func TranslateFileFromIndex(ctx context.Context, inputPath, outputPath string, translator Translator, fromItem, fromLine, fromSeg int, opts Options) error {
+	if opts.Bilingual.Enabled {
+		return errors.New("bilingual output can not be resumed from an index, resume from the checkpoint instead")
+	}
+
+	format, err := resolveFormat(opts.OutputFormat, outputPath)
+	if err != nil {
+		return err
+	}
+	translated, err := readFile(outputPath, format)
 	if err != nil {
 		return fmt.Errorf("failed to open output file for resuming: %w", err)
 	}
 
-	inputSubs, err := astisub.OpenFile(inputPath)
+	inputFormat, err := resolveFormat(opts.InputFormat, inputPath)
+	if err != nil {
+		return err
+	}
+	inputSubs, err := readFile(inputPath, inputFormat)
 	if err != nil {
 		return err
 	}
 
-	infos := extractInfos(inputSubs, translator)
+	// ASS/SSA output is prepared like the input, so the translated events are found at the same positions
+	out := prepare(outputPath, translated, format, &Options{SSA: opts.SSA})
+	subs := out.subs
+	in := prepare(inputPath, inputSubs, inputFormat, &opts)
+	infos := extractInfos(in.subs, translator, opts)
 
 	offset, err := findOffset(infos, fromItem, fromLine, fromSeg)
 	if err != nil {
@@ -135,22 +502,76 @@ func TranslateFileFromIndex(inputPath, outputPath string, translator Translator,
 
 	log.Printf("Resuming translation from item %d (offset %d)", offset, offset)
 
-	return processBatches(subs, infos, offset, offset, translator, outputPath, "Wrote partial translation with %d additional completed items")
+	pending := []int{}
+	for i := offset; i < len(infos); i++ {
+		pending = append(pending, i)
+	}
+
+	// the output file already holds the earlier translations, so no checkpoint is kept
+	ctx = WithBatchInfo(ctx, BatchInfo{Input: inputPath, TargetLang: opts.Settings.TargetLang})
+	return processBatches(ctx, subs, infos, pending, translator, output{path: outputPath, format: format, ssa: out.ssa}, "Wrote partial translation with %d additional completed items", opts, nil)
+}
+
+// translateOrSplit translates batch, if the response does not match the texts the batch is split into halves
+// down to single texts before giving up.
+func translateOrSplit(ctx context.Context, translator Translator, batch Batch) ([]string, error) {
+	translations, err := translator.Translate(ctx, batch)
+	if err == nil || len(batch.Texts) < 2 || !(errors.Is(err, ErrCountMismatch) || errors.Is(err, ErrMalformedResponse)) {
+		return translations, err
+	}
+
+	log.Printf("Splitting batch of %d items: %v", len(batch.Texts), err)
+	mid := len(batch.Texts) / 2
+
+	// each half keeps as many context lines as the whole batch had
+	first := Batch{Texts: batch.Texts[:mid], Before: batch.Before}
+	if n := len(batch.After); n > 0 {
+		first.After = append(slices.Clone(batch.Texts[mid:]), batch.After...)[:n]
+	}
+	firstTranslations, err := translateOrSplit(ctx, translator, first)
+	if err != nil {
+		return nil, err
+	}
+
+	second := Batch{Texts: batch.Texts[mid:], After: batch.After}
+	if n := len(batch.Before); n > 0 {
+		before := slices.Clone(batch.Before)
+		for i, text := range first.Texts {
+			before = append(before, ContextLine{Text: text, Translation: firstTranslations[i]})
+		}
+		second.Before = before[len(before)-n:]
+	}
+	secondTranslations, err := translateOrSplit(ctx, translator, second)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(firstTranslations, secondTranslations...), nil
 }
 
-func createBatches(infos []textInfo, maxLength int) [][]string {
-	batches := [][]string{}
-	currentBatch := []string{}
+// createBatches groups pending into batches whose texts and context lines fit maxLength and whose expected
+// translations fit maxOutputLength, a maxOutputLength of 0 means no limit.
+func createBatches(infos []textInfo, pending []int, maxLength, maxOutputLength int, opts Options) [][]int {
+	batches := [][]int{}
+	currentBatch := []int{}
 	currentLength := 0
+	currentOutputLength := 0
 
-	for _, info := range infos {
-		if (currentLength+info.length > maxLength || len(currentBatch) >= maxItemPerBatch) && len(currentBatch) > 0 {
-			batches = append(batches, currentBatch)
-			currentBatch = []string{}
-			currentLength = 0
+	for _, i := range pending {
+		info := infos[i]
+		if len(currentBatch) > 0 {
+			length := currentLength + info.length + contextLength(infos, currentBatch[0], i, opts)
+			outputLength := currentOutputLength + info.outputLength
+			if length > maxLength || (maxOutputLength > 0 && outputLength > maxOutputLength) || len(currentBatch) >= maxItemPerBatch {
+				batches = append(batches, currentBatch)
+				currentBatch = []int{}
+				currentLength = 0
+				currentOutputLength = 0
+			}
 		}
-		currentBatch = append(currentBatch, info.text)
+		currentBatch = append(currentBatch, i)
 		currentLength += info.length
+		currentOutputLength += info.outputLength
 	}
 
 	if len(currentBatch) > 0 {
@@ -160,6 +581,78 @@ func createBatches(infos []textInfo, maxLength int) [][]string {
 	return batches
 }
 
+// contextWindow returns the ranges of infos used as context for a batch from infos[first] to infos[last].
+func contextWindow(infos []textInfo, first, last int, opts Options) (before, after []int) {
+	for i := max(first-opts.ContextBefore, 0); i < first; i++ {
+		before = append(before, i)
+	}
+	for i := last + 1; i < min(last+1+opts.ContextAfter, len(infos)); i++ {
+		after = append(after, i)
+	}
+	return before, after
+}
+
+// contextLength estimates the context length of a batch, preceding lines count twice as they carry a translation.
+func contextLength(infos []textInfo, first, last int, opts Options) int {
+	before, after := contextWindow(infos, first, last, opts)
+	total := 0
+	for _, i := range before {
+		total += infos[i].length * 2
+	}
+	for _, i := range after {
+		total += infos[i].length
+	}
+	return total
+}
+
+// buildBatch collects the texts of indices and their context lines.
+func buildBatch(subs *astisub.Subtitles, infos []textInfo, indices []int, translated []bool, opts Options) Batch {
+	batch := Batch{}
+	for _, i := range indices {
+		batch.Texts = append(batch.Texts, infos[i].text)
+	}
+	before, after := contextWindow(infos, indices[0], indices[len(indices)-1], opts)
+	for _, i := range before {
+		line := ContextLine{Text: infos[i].text}
+		if translated[i] {
+			line.Translation = textAt(subs, infos[i], opts.Unit)
+		}
+		batch.Before = append(batch.Before, line)
+	}
+	for _, i := range after {
+		batch.After = append(batch.After, infos[i].text)
+	}
+	return batch
+}
+
+func textAt(subs *astisub.Subtitles, info textInfo, unit Unit) string {
+	item := subs.Items[info.itemIndex]
+	switch unit {
+	case UnitSentence:
+		return sentenceMarkup(subs.Items[info.itemIndex : info.itemIndex+info.cues])
+	case UnitCue:
+		return cueMarkup(item)
+	case UnitLine:
+		return lineMarkup(item.Lines[info.lineIndex])
+	}
+	return item.Lines[info.lineIndex].Items[info.segIndex].Text
+}
+
+func setText(subs *astisub.Subtitles, info textInfo, text string, unit Unit) {
+	item := subs.Items[info.itemIndex]
+	switch unit {
+	case UnitSentence:
+		setSentenceMarkup(subs.Items[info.itemIndex:info.itemIndex+info.cues], text)
+	case UnitCue:
+		setCueMarkup(item, text)
+	case UnitLine:
+		line := &item.Lines[info.lineIndex]
+		line.Items = fromMarkup(text, line.Items)
+	default:
+		item.Lines[info.lineIndex].Items[info.segIndex].Text = text
+	}
+}
+
 func getBatchLength(batch []string) int {
 	total := 0
 	for _, s := range batch {
@@ -1,9 +1,11 @@
 package sub
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -12,8 +14,20 @@ import (
 type mockTranslator struct {
 	translations map[string]string
 	maxLength    int
-	translateErr error
-	callCount    int
+	// maxOutputLength limits the translations of a batch, 0 means no limit
+	maxOutputLength int
+	translateErr    error
+	// failOn fails any batch containing one of these texts
+	failOn map[string]error
+	// maxTexts fails batches with more texts with ErrCountMismatch, 0 means no limit
+	maxTexts int
+	// onTranslate is called with each batch before translating it
+	onTranslate func(texts []string)
+	mu          sync.Mutex
+	callCount   int
+	batches     []Batch
+	// infos records the batch info of every call
+	infos []BatchInfo
 }
 
 func (m *mockTranslator) Length(text string) int {
@@ -24,11 +38,40 @@ func (m *mockTranslator) MaxLength() int {
 	return m.maxLength
 }
 
-func (m *mockTranslator) Translate(texts []string) ([]string, error) {
+func (m *mockTranslator) OutputLength(text string) int {
+	return 1
+}
+
+func (m *mockTranslator) MaxOutputLength() int {
+	return m.maxOutputLength
+}
+
+func (m *mockTranslator) Translate(ctx context.Context, batch Batch) ([]string, error) {
+	texts := batch.Texts
+	if m.onTranslate != nil {
+		m.onTranslate(texts)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
 	m.callCount++
-	if m.callCount == 2 && m.translateErr != nil {
+	callCount := m.callCount
+	m.batches = append(m.batches, batch)
+	info, _ := BatchInfoFrom(ctx)
+	m.infos = append(m.infos, info)
+	m.mu.Unlock()
+	if callCount == 2 && m.translateErr != nil {
 		return nil, m.translateErr
 	}
+	for _, text := range texts {
+		if err, ok := m.failOn[text]; ok {
+			return nil, err
+		}
+	}
+	if m.maxTexts > 0 && len(texts) > m.maxTexts {
+		return nil, fmt.Errorf("%w: got %d translations for %d input texts", ErrCountMismatch, m.maxTexts, len(texts))
+	}
 	result := make([]string, len(texts))
 	for i, text := range texts {
 		if trans, ok := m.translations[text]; ok {
@@ -74,7 +117,7 @@ Hola mundo
 		maxLength: 10,
 	}
 
-	err = TranslateFile(tmpInput, tmpOutput, translator)
+	err = TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{})
 	assert.NoError(t, err)
 
 	outputContent, err := os.ReadFile(tmpOutput)
@@ -82,6 +125,51 @@ Hola mundo
 	assert.Equal(t, expected, string(outputContent))
 }
 
+func TestTranslateFileTargets(t *testing.T) {
+	inputContent := `1
+00:00:01,000 --> 00:00:04,000
+Hello world
+
+2
+00:00:05,000 --> 00:00:08,000
+How are you?
+`
+	tmpDir := t.TempDir()
+	tmpInput := filepath.Join(tmpDir, "input.srt")
+	assert.NoError(t, os.WriteFile(tmpInput, []byte(inputContent), 0644))
+
+	spanish := &mockTranslator{translations: map[string]string{"Hello world": "Hola mundo", "How are you?": "¿Cómo estás?"}, maxLength: 1}
+	german := &mockTranslator{translations: map[string]string{"Hello world": "Hallo Welt"}, maxLength: 1, failOn: map[string]error{"How are you?": assert.AnError}}
+	french := &mockTranslator{translations: map[string]string{"Hello world": "Bonjour le monde", "How are you?": "Comment ça va ?"}, maxLength: 1}
+	targets := []Target{
+		{Lang: "Spanish", OutputPath: filepath.Join(tmpDir, "output.es.srt"), Translator: spanish},
+		{Lang: "German", OutputPath: filepath.Join(tmpDir, "output.de.srt"), Translator: german},
+		{Lang: "French", OutputPath: filepath.Join(tmpDir, "output.fr.srt"), Translator: french},
+	}
+
+	err := TranslateFileTargets(t.Context(), tmpInput, targets, Options{Checkpoint: true, Settings: RunSettings{TargetLang: "Spanish"}})
+	// the failed German translation does not stop the French one
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ErrorContains(t, err, targets[1].OutputPath)
+
+	spanishOutput, err := os.ReadFile(targets[0].OutputPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(spanishOutput), "Hola mundo")
+	assert.Contains(t, string(spanishOutput), "¿Cómo estás?")
+	frenchOutput, err := os.ReadFile(targets[2].OutputPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(frenchOutput), "Bonjour le monde")
+	assert.NotContains(t, string(frenchOutput), "Hola", "every target translates its own copy")
+
+	// all targets share the batches of the first translator
+	assert.Equal(t, spanish.batches, french.batches)
+	assert.Equal(t, []BatchInfo{{Input: tmpInput, TargetLang: "French", Batch: 1}, {Input: tmpInput, TargetLang: "French", Batch: 2}}, french.infos)
+	// the checkpoint of the failed target records its language
+	checkpointData, err := os.ReadFile(CheckpointPath(targets[1].OutputPath))
+	assert.NoError(t, err)
+	assert.Contains(t, string(checkpointData), `"target_lang":"German"`)
+}
+
 func TestTranslateFileBatch(t *testing.T) {
 	inputContent := `1
 00:00:01,000 --> 00:00:04,000
@@ -135,7 +223,7 @@ Trans 4
 		maxLength: 2,
 	}
 
-	err = TranslateFile(tmpInput, tmpOutput, translator)
+	err = TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{})
 	assert.NoError(t, err)
 
 	outputContent, err := os.ReadFile(tmpOutput)
@@ -180,7 +268,7 @@ func TestTranslateFileMultiSegments(t *testing.T) {
 		maxLength: 10,
 	}
 
-	err = TranslateFile(tmpInput, tmpOutput, translator)
+	err = TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{})
 	assert.NoError(t, err)
 
 	outputContent, err := os.ReadFile(tmpOutput)
@@ -241,14 +329,14 @@ Line 4
 		translateErr: fmt.Errorf("translation service unavailable"),
 	}
 
-	err = TranslateFile(tmpInput, tmpOutput, translator)
+	err = TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{})
 	assert.Error(t, err)
 
 	var translationErr *TranslationError
 	assert.ErrorAs(t, err, &translationErr)
 	assert.Equal(t, 2, translationErr.BatchNumber)
 	assert.Equal(t, 2, translationErr.CompletedItems)
-	assert.Equal(t, textInfo{itemIndex: 2, lineIndex: 0, segIndex: 0, length: 1, text: "Line 3"}, translationErr.FirstFailed)
+	assert.Equal(t, textInfo{itemIndex: 2, lineIndex: 0, segIndex: 0, length: 1, text: "Line 3", outputLength: 1}, translationErr.FirstFailed)
 	assert.Equal(t, fmt.Errorf("translation service unavailable"), translationErr.Err)
 
 	// Output file should contain partial translation
@@ -290,7 +378,7 @@ Line 2
 		maxLength: 10,
 	}
 
-	err = TranslateFileFromIndex(tmpInput, tmpOutput, translator, 99, 0, 0)
+	err = TranslateFileFromIndex(t.Context(), tmpInput, tmpOutput, translator, 99, 0, 0, Options{})
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not found in input file")
 }
@@ -367,10 +455,312 @@ Trans 4
 		maxLength: 10,
 	}
 
-	err = TranslateFileFromIndex(tmpInput, tmpOutput, translator, 2, 0, 0)
+	err = TranslateFileFromIndex(t.Context(), tmpInput, tmpOutput, translator, 2, 0, 0, Options{})
 	assert.NoError(t, err)
 
 	outputContent, err := os.ReadFile(tmpOutput)
 	assert.NoError(t, err)
 	assert.Equal(t, expected, string(outputContent))
 }
+
+const fourLinesSRT = `1
+00:00:01,000 --> 00:00:04,000
+Line 1
+
+2
+00:00:05,000 --> 00:00:08,000
+Line 2
+
+3
+00:00:09,000 --> 00:00:12,000
+Line 3
+
+4
+00:00:13,000 --> 00:00:16,000
+Line 4
+`
+
+func TestTranslateFileConcurrent(t *testing.T) {
+	expected := "\ufeff" + `1
+00:00:01,000 --> 00:00:04,000
+Trans 1
+
+2
+00:00:05,000 --> 00:00:08,000
+Trans 2
+
+3
+00:00:09,000 --> 00:00:12,000
+Trans 3
+
+4
+00:00:13,000 --> 00:00:16,000
+Trans 4
+`
+
+	tmpDir := t.TempDir()
+	tmpInput := filepath.Join(tmpDir, "input.srt")
+	tmpOutput := filepath.Join(tmpDir, "output.srt")
+
+	err := os.WriteFile(tmpInput, []byte(fourLinesSRT), 0644)
+	assert.NoError(t, err)
+
+	translator := &mockTranslator{
+		translations: map[string]string{
+			"Line 1": "Trans 1",
+			"Line 2": "Trans 2",
+			"Line 3": "Trans 3",
+			"Line 4": "Trans 4",
+		},
+		// one item per batch
+		maxLength: 1,
+	}
+
+	err = TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{Concurrency: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, translator.callCount)
+
+	outputContent, err := os.ReadFile(tmpOutput)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(outputContent))
+}
+
+func TestTranslateFileConcurrentFails(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpInput := filepath.Join(tmpDir, "input.srt")
+	tmpOutput := filepath.Join(tmpDir, "output.srt")
+
+	err := os.WriteFile(tmpInput, []byte(fourLinesSRT), 0644)
+	assert.NoError(t, err)
+
+	translator := &mockTranslator{
+		translations: map[string]string{
+			"Line 1": "Trans 1",
+			"Line 2": "Trans 2",
+			"Line 3": "Trans 3",
+			"Line 4": "Trans 4",
+		},
+		maxLength: 1,
+		failOn: map[string]error{
+			"Line 2": fmt.Errorf("translation service unavailable"),
+		},
+	}
+
+	err = TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{Concurrency: 4})
+	assert.Error(t, err)
+
+	var translationErr *TranslationError
+	assert.ErrorAs(t, err, &translationErr)
+	assert.Equal(t, 2, translationErr.BatchNumber)
+	assert.Equal(t, 1, translationErr.CompletedItems)
+	assert.Equal(t, textInfo{itemIndex: 1, lineIndex: 0, segIndex: 0, length: 1, text: "Line 2", outputLength: 1}, translationErr.FirstFailed)
+
+	// Output file should contain at least the batches before the failed one
+	outputContent, err := os.ReadFile(tmpOutput)
+	assert.NoError(t, err)
+	assert.Contains(t, string(outputContent), "Trans 1")
+	assert.Contains(t, string(outputContent), "Line 2")
+}
+
+func TestTranslateFileCancelled(t *testing.T) {
+	expectedPartial := "\ufeff" + `1
+00:00:01,000 --> 00:00:04,000
+Trans 1
+
+2
+00:00:05,000 --> 00:00:08,000
+Line 2
+
+3
+00:00:09,000 --> 00:00:12,000
+Line 3
+
+4
+00:00:13,000 --> 00:00:16,000
+Line 4
+`
+
+	tmpDir := t.TempDir()
+	tmpInput := filepath.Join(tmpDir, "input.srt")
+	tmpOutput := filepath.Join(tmpDir, "output.srt")
+
+	err := os.WriteFile(tmpInput, []byte(fourLinesSRT), 0644)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	translator := &mockTranslator{
+		translations: map[string]string{
+			"Line 1": "Trans 1",
+			"Line 2": "Trans 2",
+			"Line 3": "Trans 3",
+			"Line 4": "Trans 4",
+		},
+		maxLength: 1,
+		onTranslate: func(texts []string) {
+			if texts[0] == "Line 2" {
+				cancel()
+			}
+		},
+	}
+
+	err = TranslateFile(ctx, tmpInput, tmpOutput, translator, Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	var translationErr *TranslationError
+	assert.ErrorAs(t, err, &translationErr)
+	assert.Equal(t, 1, translationErr.CompletedItems)
+	item, line, seg := translationErr.ResumeIndex()
+	assert.Equal(t, []int{1, 0, 0}, []int{item, line, seg})
+
+	outputContent, err := os.ReadFile(tmpOutput)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPartial, string(outputContent))
+}
+
+func TestTranslateFileContext(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpInput := filepath.Join(tmpDir, "input.srt")
+	tmpOutput := filepath.Join(tmpDir, "output.srt")
+
+	err := os.WriteFile(tmpInput, []byte(fourLinesSRT), 0644)
+	assert.NoError(t, err)
+
+	translator := &mockTranslator{
+		translations: map[string]string{
+			"Line 1": "Trans 1",
+			"Line 2": "Trans 2",
+			"Line 3": "Trans 3",
+			"Line 4": "Trans 4",
+		},
+		// texts and context lines, a preceding line counts twice
+		maxLength: 3,
+	}
+
+	err = TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{ContextBefore: 1, ContextAfter: 1})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Batch{
+		{Texts: []string{"Line 1", "Line 2"}, After: []string{"Line 3"}},
+		{Texts: []string{"Line 3"}, Before: []ContextLine{{Text: "Line 2", Translation: "Trans 2"}}, After: []string{"Line 4"}},
+		{Texts: []string{"Line 4"}, Before: []ContextLine{{Text: "Line 3", Translation: "Trans 3"}}},
+	}, translator.batches)
+}
+
+func TestCreateBatchesContextBudget(t *testing.T) {
+	infos := make([]textInfo, 6)
+	pending := make([]int, len(infos))
+	for i := range infos {
+		infos[i] = textInfo{itemIndex: i, length: 1, outputLength: 2, text: fmt.Sprintf("Line %d", i+1)}
+		pending[i] = i
+	}
+
+	tests := []struct {
+		name            string
+		opts            Options
+		maxOutputLength int
+		want            [][]int
+	}{
+		{
+			name: "no context",
+			opts: Options{},
+			want: [][]int{{0, 1, 2, 3}, {4, 5}},
+		},
+		{
+			name: "context reduces batch size",
+			opts: Options{ContextBefore: 1, ContextAfter: 1},
+			want: [][]int{{0, 1, 2}, {3}, {4, 5}},
+		},
+		{
+			name:            "output limit reduces batch size",
+			opts:            Options{},
+			maxOutputLength: 5,
+			want:            [][]int{{0, 1}, {2, 3}, {4, 5}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, createBatches(infos, pending, 4, tt.maxOutputLength, tt.opts))
+		})
+	}
+}
+
+func TestTranslateFileSplitsMismatchedBatch(t *testing.T) {
+	expected := "\ufeff" + `1
+00:00:01,000 --> 00:00:04,000
+Trans 1
+
+2
+00:00:05,000 --> 00:00:08,000
+Trans 2
+
+3
+00:00:09,000 --> 00:00:12,000
+Trans 3
+
+4
+00:00:13,000 --> 00:00:16,000
+Trans 4
+`
+
+	tmpDir := t.TempDir()
+	tmpInput := filepath.Join(tmpDir, "input.srt")
+	tmpOutput := filepath.Join(tmpDir, "output.srt")
+
+	err := os.WriteFile(tmpInput, []byte(fourLinesSRT), 0644)
+	assert.NoError(t, err)
+
+	translator := &mockTranslator{
+		translations: map[string]string{
+			"Line 1": "Trans 1",
+			"Line 2": "Trans 2",
+			"Line 3": "Trans 3",
+			"Line 4": "Trans 4",
+		},
+		maxLength: 10,
+		maxTexts:  1,
+	}
+
+	err = TranslateFile(t.Context(), tmpInput, tmpOutput, translator, Options{})
+	assert.NoError(t, err)
+	// 4 texts, then 2 halves, then 4 single texts
+	assert.Equal(t, 7, translator.callCount)
+
+	outputContent, err := os.ReadFile(tmpOutput)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(outputContent))
+}
+
+func TestTranslateOrSplitContext(t *testing.T) {
+	translator := &mockTranslator{
+		translations: map[string]string{"B": "b", "C": "c"},
+		maxTexts:     1,
+	}
+	batch := Batch{
+		Texts:  []string{"B", "C"},
+		Before: []ContextLine{{Text: "A", Translation: "a"}},
+		After:  []string{"D"},
+	}
+
+	got, err := translateOrSplit(t.Context(), translator, batch)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, got)
+	assert.Equal(t, []Batch{
+		batch,
+		{Texts: []string{"B"}, Before: []ContextLine{{Text: "A", Translation: "a"}}, After: []string{"C"}},
+		{Texts: []string{"C"}, Before: []ContextLine{{Text: "B", Translation: "b"}}, After: []string{"D"}},
+	}, translator.batches)
+}
+
+func TestTranslateOrSplitGivesUp(t *testing.T) {
+	translator := &mockTranslator{
+		failOn: map[string]error{"B": fmt.Errorf("%w: not JSON", ErrMalformedResponse)},
+	}
+
+	_, err := translateOrSplit(t.Context(), translator, Batch{Texts: []string{"A", "B"}})
+	assert.ErrorIs(t, err, ErrMalformedResponse)
+	// the whole batch, then "A" and "B" alone
+	assert.Equal(t, 3, translator.callCount)
+}
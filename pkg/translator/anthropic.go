@@ -0,0 +1,101 @@
+package translator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+)
+
+const (
+	anthropicSystemPrompt  = "You are a professional subtitle translator. Submit the translations with the " + submitTranslationsTool + " tool."
+	submitTranslationsTool = "submit_translations"
+	// submitTranslationsDescription describes the submit_translations tool to the model.
+	submitTranslationsDescription = "Submit the translated subtitle texts in the order of the input texts."
+)
+
+type AnthropicTranslator struct {
+	budget
+	Config     *config.Config
+	Provider   config.LLMProvider
+	client     anthropic.Client
+	promptTmpl string
+	dryRun     bool
+}
+
+func newAnthropicTranslator(cfg *config.Config, provider config.LLMProvider, promptKey string, dryRun bool) (*AnthropicTranslator, error) {
+	promptTmpl, err := PromptTemplate(cfg, promptKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prompt template: %w", err)
+	}
+
+	opts := []option.RequestOption{
+		option.WithAPIKey(provider.APIKey),
+		// requests are retried by retryTranslator
+		option.WithMaxRetries(0),
+	}
+	if provider.APIURL != "" {
+		opts = append(opts, option.WithBaseURL(provider.APIURL))
+	}
+
+	return &AnthropicTranslator{
+		Config:     cfg,
+		Provider:   provider,
+		client:     anthropic.NewClient(opts...),
+		promptTmpl: promptTmpl,
+		dryRun:     dryRun,
+		budget:     newBudget(cfg, provider, promptTmpl),
+	}, nil
+}
+
+func (t *AnthropicTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	texts := batch.Texts
+	if t.dryRun {
+		return make([]string, len(texts)), nil
+	}
+	if len(texts) == 0 {
+		return []string{}, nil
+	}
+
+	prompt, err := toPrompt(t.promptTmpl, t.Config.TargetLang, t.Config.Glossary, batch)
+	if err != nil {
+		return texts, err
+	}
+
+	// the tool input schema is the translation response, forcing the tool makes the response structured
+	tool := anthropic.ToolUnionParamOfTool(anthropic.ToolInputSchemaParam{
+		Properties: translationResponseJSONSchema.Properties,
+		Required:   translationResponseJSONSchema.Required,
+	}, submitTranslationsTool)
+	tool.OfTool.Description = anthropic.String(submitTranslationsDescription)
+
+	message, err := t.client.Messages.New(ctx, anthropic.MessageNewParams{
+		Model:     anthropic.Model(t.Provider.Model),
+		MaxTokens: int64(t.Provider.MaxOutputTokens),
+		System:    []anthropic.TextBlockParam{{Text: anthropicSystemPrompt}},
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)),
+		},
+		Tools:      []anthropic.ToolUnionParam{tool},
+		ToolChoice: anthropic.ToolChoiceParamOfTool(submitTranslationsTool),
+	})
+	if err != nil {
+		return texts, fmt.Errorf("failed to get message from Anthropic API: %w", err)
+	}
+	// input tokens exclude the tokens read from and written to the prompt cache
+	reportUsage(ctx, Usage{
+		PromptTokens:     message.Usage.InputTokens + message.Usage.CacheReadInputTokens + message.Usage.CacheCreationInputTokens,
+		CompletionTokens: message.Usage.OutputTokens,
+		CachedTokens:     message.Usage.CacheReadInputTokens,
+	})
+
+	for _, block := range message.Content {
+		if block.Type == "tool_use" && block.Name == submitTranslationsTool {
+			return parseTranslationResponse(string(block.Input), texts)
+		}
+	}
+	return texts, fmt.Errorf("%w: %w to %s in Anthropic response (stop reason %s)", sub.ErrMalformedResponse, errNoToolCall, submitTranslationsTool, message.StopReason)
+}
@@ -0,0 +1,97 @@
+package translator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAnthropicStandIn serves the Messages API, responding with content blocks or the status code.
+func newAnthropicStandIn(t *testing.T, status int, content []map[string]any) (*httptest.Server, *map[string]any) {
+	var request map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/messages", r.URL.Path)
+		assert.Equal(t, "test-key", r.Header.Get("X-Api-Key"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		w.Header().Set("Content-Type", "application/json")
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":          "msg_1",
+			"type":        "message",
+			"role":        "assistant",
+			"model":       "claude-test",
+			"content":     content,
+			"stop_reason": "tool_use",
+			"usage":       map[string]any{"input_tokens": 10, "output_tokens": 5},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &request
+}
+
+func newTestAnthropicTranslator(t *testing.T, url string) *AnthropicTranslator {
+	cfg := &config.Config{TargetLang: "Spanish"}
+	provider := config.LLMProvider{API: config.Anthropic, APIKey: "test-key", APIURL: url, Model: "claude-test", MaxTokens: 1000, MaxOutputTokens: 2048}
+	tr, err := newAnthropicTranslator(cfg, provider, "default", false)
+	require.NoError(t, err)
+	return tr
+}
+
+func TestAnthropicTranslator(t *testing.T) {
+	server, request := newAnthropicStandIn(t, http.StatusOK, []map[string]any{
+		{"type": "text", "text": "Here you go."},
+		{"type": "tool_use", "id": "toolu_1", "name": submitTranslationsTool, "input": map[string]any{"translations": []string{"Hola", "Adiós"}}},
+	})
+	tr := newTestAnthropicTranslator(t, server.URL)
+
+	got, err := tr.Translate(t.Context(), sub.Batch{Texts: []string{"Hello", "Bye"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hola", "Adiós"}, got)
+
+	assert.Equal(t, "claude-test", (*request)["model"])
+	assert.Equal(t, float64(2048), (*request)["max_tokens"])
+	assert.Equal(t, []any{map[string]any{"type": "text", "text": anthropicSystemPrompt}}, (*request)["system"])
+	assert.Equal(t, map[string]any{"type": "tool", "name": submitTranslationsTool}, (*request)["tool_choice"])
+
+	tools := (*request)["tools"].([]any)
+	require.Len(t, tools, 1)
+	tool := tools[0].(map[string]any)
+	assert.Equal(t, submitTranslationsTool, tool["name"])
+	schema := tool["input_schema"].(map[string]any)
+	assert.Equal(t, "object", schema["type"])
+	assert.Equal(t, []any{"translations"}, schema["required"])
+	assert.Contains(t, schema["properties"], "translations")
+
+	messages := (*request)["messages"].([]any)
+	require.Len(t, messages, 1)
+	content := messages[0].(map[string]any)["content"].([]any)
+	assert.Contains(t, content[0].(map[string]any)["text"], `[{"id":1,"text":"Hello"},{"id":2,"text":"Bye"}]`)
+}
+
+func TestAnthropicTranslatorErrors(t *testing.T) {
+	server, _ := newAnthropicStandIn(t, http.StatusOK, []map[string]any{{"type": "text", "text": "Hola"}})
+	_, err := newTestAnthropicTranslator(t, server.URL).Translate(t.Context(), sub.Batch{Texts: []string{"Hello"}})
+	assert.ErrorIs(t, err, sub.ErrMalformedResponse)
+
+	server, _ = newAnthropicStandIn(t, http.StatusOK, []map[string]any{
+		{"type": "tool_use", "id": "toolu_1", "name": submitTranslationsTool, "input": map[string]any{"translations": []string{"Hola"}}},
+	})
+	_, err = newTestAnthropicTranslator(t, server.URL).Translate(t.Context(), sub.Batch{Texts: []string{"Hello", "Bye"}})
+	assert.ErrorIs(t, err, sub.ErrCountMismatch)
+
+	server, _ = newAnthropicStandIn(t, http.StatusTooManyRequests, nil)
+	_, err = newTestAnthropicTranslator(t, server.URL).Translate(t.Context(), sub.Batch{Texts: []string{"Hello"}})
+	require.Error(t, err)
+	assert.True(t, isRetryable(err))
+}
@@ -0,0 +1,110 @@
+package translator
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+)
+
+const (
+	// defaultExpansionFactor is the expected ratio of translation to source tokens without a configured one
+	defaultExpansionFactor = 1.5
+	// lengthMargin is the part of the token limits batches are sized for, token counts are estimates
+	lengthMargin = 0.95
+)
+
+// budget sizes batches of a provider, so the prompt with all texts and context lines fits the input limit and the
+// expected JSON answer fits the output limit.
+type budget struct {
+	countTokens tokenCounter
+	maxInput    int
+	maxOutput   int // 0 means no limit
+	// overhead is the prompt without texts, with every note and glossary entry that might be added
+	overhead int
+	// responseOverhead is the JSON answer without translations
+	responseOverhead int
+	expansion        float64
+}
+
+func newBudget(cfg *config.Config, provider config.LLMProvider, promptTmpl string) budget {
+	countTokens := newTokenCounter(provider)
+
+	maxInput := provider.MaxInputTokens
+	if maxInput == 0 {
+		maxInput = provider.MaxTokens
+		if provider.MaxOutputTokens > 0 && provider.MaxOutputTokens < maxInput {
+			// the context window holds the prompt and the response
+			maxInput -= provider.MaxOutputTokens
+		}
+	}
+
+	return budget{
+		countTokens:      countTokens,
+		maxInput:         maxInput,
+		maxOutput:        provider.MaxOutputTokens,
+		overhead:         countTokens(promptOverhead(cfg, promptTmpl)),
+		responseOverhead: countTokens(`{"translations":[]}`),
+		expansion:        expansionFactor(cfg),
+	}
+}
+
+// promptOverhead renders the prompt without texts plus every section that may be added to it.
+func promptOverhead(cfg *config.Config, promptTmpl string) string {
+	prompt, _ := toPrompt(promptTmpl, cfg.TargetLang, config.Glossary{}, sub.Batch{Texts: []string{}})
+	terms := slices.Concat(slices.Collect(maps.Keys(cfg.Glossary.Terms)), cfg.Glossary.Keep)
+	contextSection, _ := toContextSection(sub.Batch{Before: []sub.ContextLine{{}}, After: []string{""}})
+	return strings.Join([]string{
+		prompt,
+		multiLangNote,
+		markupNote,
+		sentenceNote,
+		toGlossarySection(cfg.Glossary, []string{strings.Join(terms, " ")}),
+		contextSection,
+	}, "\n")
+}
+
+// expansionFactor returns the configured expansion factor of the target language, the largest one of the languages
+// of a multi-language request.
+func expansionFactor(cfg *config.Config) float64 {
+	expansion := 0.0
+	for _, target := range config.ParseLangs(cfg.TargetLang) {
+		factor := defaultExpansionFactor
+		for lang, f := range cfg.ExpansionFactors {
+			if strings.EqualFold(lang, target) {
+				factor = f
+			}
+		}
+		expansion = max(expansion, factor)
+	}
+	if expansion == 0 {
+		return defaultExpansionFactor
+	}
+	return expansion
+}
+
+// Length is the length of the entry of text in the JSON array of the prompt.
+func (b budget) Length(text string) int {
+	entry, _ := marshalJSON(toEntry(100, text))
+	// one more for the separating comma
+	return b.countTokens(string(entry)) + 1
+}
+
+func (b budget) MaxLength() int {
+	return max(int(float64(b.maxInput)*lengthMargin)-b.overhead, 1)
+}
+
+// OutputLength is the expected length of the entry of the translation of text in the JSON answer.
+func (b budget) OutputLength(text string) int {
+	return int(math.Ceil(float64(b.Length(text)) * b.expansion))
+}
+
+func (b budget) MaxOutputLength() int {
+	if b.maxOutput == 0 {
+		return 0
+	}
+	return max(int(float64(b.maxOutput)*lengthMargin)-b.responseOverhead, 1)
+}
@@ -0,0 +1,53 @@
+package translator
+
+import (
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudget(t *testing.T) {
+	cfg := &config.Config{TargetLang: "German"}
+	provider := config.LLMProvider{API: config.OpenAI, Model: "gpt-4o", MaxTokens: 1000, MaxOutputTokens: 200}
+
+	b := newBudget(cfg, provider, defaultPromptTmpl)
+	assert.Equal(t, 800, b.maxInput)
+	assert.Positive(t, b.overhead)
+	assert.Equal(t, 760-b.overhead, b.MaxLength())
+	assert.Equal(t, 190-b.responseOverhead, b.MaxOutputLength())
+
+	// the id, quotes and the comma count too
+	assert.Greater(t, b.Length("Hello"), b.countTokens("Hello"))
+	assert.Equal(t, 12, b.Length("one two three"))
+	assert.Equal(t, 18, b.OutputLength("one two three"))
+
+	provider.MaxInputTokens = 500
+	assert.Equal(t, 500, newBudget(cfg, provider, defaultPromptTmpl).maxInput)
+
+	provider.MaxOutputTokens = 0
+	assert.Equal(t, 0, newBudget(cfg, provider, defaultPromptTmpl).MaxOutputLength())
+}
+
+func TestBudgetGlossaryOverhead(t *testing.T) {
+	provider := config.LLMProvider{API: config.OpenAI, Model: "gpt-4o", MaxTokens: 1000}
+	plain := newBudget(&config.Config{TargetLang: "German"}, provider, defaultPromptTmpl)
+	withGlossary := newBudget(&config.Config{
+		TargetLang: "German",
+		Glossary:   config.Glossary{Terms: map[string]string{"Frodo": "Frodo Beutlin"}, Keep: []string{"Shire"}},
+	}, provider, defaultPromptTmpl)
+	assert.Greater(t, withGlossary.overhead, plain.overhead)
+	assert.Less(t, withGlossary.MaxLength(), plain.MaxLength())
+}
+
+func TestExpansionFactor(t *testing.T) {
+	assert.Equal(t, defaultExpansionFactor, expansionFactor(&config.Config{TargetLang: "German"}))
+	assert.Equal(t, 2.0, expansionFactor(&config.Config{TargetLang: "German", ExpansionFactors: map[string]float64{"german": 2}}))
+	// a multi-language request expects the largest factor of its languages
+	assert.Equal(t, 2.0, expansionFactor(&config.Config{TargetLang: "French, German", ExpansionFactors: map[string]float64{"german": 2, "french": 1.2}}))
+	assert.Equal(t, defaultExpansionFactor, expansionFactor(&config.Config{TargetLang: "French, German", ExpansionFactors: map[string]float64{"french": 1.2}}))
+
+	provider := config.LLMProvider{API: config.OpenAI, Model: "gpt-4o", MaxTokens: 1000}
+	b := newBudget(&config.Config{TargetLang: "German", ExpansionFactors: map[string]float64{"German": 2}}, provider, defaultPromptTmpl)
+	assert.Equal(t, 2*b.Length("one two three"), b.OutputLength("one two three"))
+}
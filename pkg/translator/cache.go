@@ -0,0 +1,180 @@
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync/atomic"
+
+	"github.com/charleshuang3/subtrans/pkg/cache"
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+)
+
+// CacheProvider identifies a provider translations are cached for.
+type CacheProvider struct {
+	Name  string
+	API   string
+	Model string
+}
+
+// CacheProviders returns the providers of the translator of llmProvider in the order they are tried, the provider
+// itself followed by its fallbacks.
+func CacheProviders(cfg *config.Config, llmProvider string) ([]CacheProvider, error) {
+	provider, err := cfg.ResolveLLM(llmProvider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get LLM provider '%s': %w", llmProvider, err)
+	}
+	name := llmProvider
+	if name == "default" {
+		name = cfg.DefaultLLM
+	}
+	providers := []CacheProvider{{Name: name, API: provider.API, Model: provider.Model}}
+	for _, name := range provider.Fallback {
+		p, err := cfg.GetLLM(name)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, CacheProvider{Name: name, API: p.API, Model: p.Model})
+	}
+	return providers, nil
+}
+
+// producerKey is the context key of the provider name a translation request records its producer in.
+type producerKey struct{}
+
+// withProducer returns ctx in which the translator producing the translations of a request records its provider
+// name into name.
+func withProducer(ctx context.Context, name *string) context.Context {
+	return context.WithValue(ctx, producerKey{}, name)
+}
+
+// setProducer records the provider name that translated the request of ctx.
+func setProducer(ctx context.Context, name string) {
+	if p, ok := ctx.Value(producerKey{}).(*string); ok {
+		*p = name
+	}
+}
+
+// CacheScope identifies the settings a cached translation was made with, a translation is only reused with the
+// same settings.
+type CacheScope struct {
+	// Providers are the providers of the translator in the order they are tried, a translation is cached for the
+	// provider that made it and looked up for every provider in this order. There is at least one.
+	Providers  []CacheProvider
+	Prompt     string // hash of the prompt template
+	TargetLang string
+	Glossary   string // hash of the glossary, empty without one
+	// Context adds the context lines of a batch to the key, reusing translations only in the same surroundings.
+	Context bool
+}
+
+// CacheTranslator looks up every text in the cache before translating it and stores new translations. Translations
+// that keep the markup of their texts and use the glossary terms are stored, the ones whose markup was stripped or
+// that miss a term are not reused.
+type CacheTranslator struct {
+	sub.Translator
+	cache    *cache.Cache
+	scope    CacheScope
+	glossary config.Glossary
+	hits     atomic.Int64
+	misses   atomic.Int64
+}
+
+func NewCacheTranslator(t sub.Translator, c *cache.Cache, scope CacheScope, glossary config.Glossary) *CacheTranslator {
+	return &CacheTranslator{
+		Translator: t,
+		cache:      c,
+		scope:      scope,
+		glossary:   glossary,
+	}
+}
+
+// Stats returns the number of texts found in and missing from the cache so far.
+func (t *CacheTranslator) Stats() (hits, misses int64) {
+	return t.hits.Load(), t.misses.Load()
+}
+
+func (t *CacheTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	contextKey := ""
+	if t.scope.Context {
+		data, err := json.Marshal([]any{batch.Before, batch.After})
+		if err != nil {
+			return nil, err
+		}
+		contextKey = string(data)
+	}
+
+	translations := make([]string, len(batch.Texts))
+	missing := []int{}
+	for i, text := range batch.Texts {
+		if translation, ok := t.get(contextKey, text); ok {
+			translations[i] = translation
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	t.hits.Add(int64(len(batch.Texts) - len(missing)))
+	t.misses.Add(int64(len(missing)))
+	if len(missing) == 0 {
+		return translations, nil
+	}
+
+	// the cached texts are left out, the context lines stay the same
+	request := sub.Batch{Before: batch.Before, After: batch.After}
+	for _, i := range missing {
+		request.Texts = append(request.Texts, batch.Texts[i])
+	}
+	var producer string
+	results, err := t.Translator.Translate(withProducer(ctx, &producer), request)
+	if err != nil {
+		return nil, err
+	}
+	// a translator without fallbacks does not record its provider
+	p := t.scope.Providers[0]
+	if i := slices.IndexFunc(t.scope.Providers, func(p CacheProvider) bool { return p.Name == producer }); i >= 0 {
+		p = t.scope.Providers[i]
+	}
+
+	entries := make(map[string]string, len(missing))
+	for j, i := range missing {
+		translations[i] = results[j]
+		if t.accepted(batch.Texts[i], results[j]) {
+			entries[t.key(p, contextKey, batch.Texts[i])] = results[j]
+		}
+	}
+	if err := t.cache.Put(entries); err != nil {
+		log.Printf("Warning: failed to write cache: %v", err)
+	}
+	return translations, nil
+}
+
+func (t *CacheTranslator) key(p CacheProvider, contextKey, text string) string {
+	return cache.Key(p.API, p.Model, t.scope.Prompt, t.scope.TargetLang, t.scope.Glossary, contextKey, text)
+}
+
+// get returns the cached translation of text, made by the first provider that has one.
+func (t *CacheTranslator) get(contextKey, text string) (string, bool) {
+	for _, p := range t.scope.Providers {
+		translation, ok, err := t.cache.Get(t.key(p, contextKey, text))
+		if err != nil {
+			log.Printf("Warning: failed to read cache: %v", err)
+		}
+		if ok {
+			return translation, true
+		}
+	}
+	return "", false
+}
+
+// accepted reports whether translation passed the checks of the translator: it keeps the markup of text, which is
+// stripped when the placeholders can not be restored, and uses the glossary terms of text.
+func (t *CacheTranslator) accepted(text, translation string) bool {
+	_, markup := protect(text)
+	_, translationMarkup := protect(translation)
+	slices.Sort(markup)
+	slices.Sort(translationMarkup)
+	return slices.Equal(markup, translationMarkup) && len(glossaryViolations(t.glossary, text, translation)) == 0
+}
@@ -0,0 +1,137 @@
+package translator
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/cache"
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testCacheProviders = []CacheProvider{{Name: "openai", API: "openai", Model: "gpt-4"}, {Name: "claude", API: "anthropic", Model: "claude"}}
+
+func TestCacheTranslator(t *testing.T) {
+	c, err := cache.Open(filepath.Join(t.TempDir(), "translations.db"))
+	require.NoError(t, err)
+	defer c.Close()
+
+	scope := CacheScope{Providers: testCacheProviders, Prompt: "hash", TargetLang: "Spanish"}
+	fake := &fakeTranslator{}
+	ct := NewCacheTranslator(fake, c, scope, config.Glossary{})
+
+	got, err := ct.Translate(t.Context(), sub.Batch{Texts: []string{"a", "b"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, got)
+
+	// only the new text is requested, with the same context
+	batch := sub.Batch{Texts: []string{"b", "c", "a"}, After: []string{"d"}}
+	got, err = ct.Translate(t.Context(), batch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c", "a"}, got)
+	require.Len(t, fake.batches, 2)
+	assert.Equal(t, sub.Batch{Texts: []string{"c"}, After: []string{"d"}}, fake.batches[1])
+
+	_, err = ct.Translate(t.Context(), sub.Batch{Texts: []string{"c"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, fake.calls)
+
+	hits, misses := ct.Stats()
+	assert.Equal(t, int64(3), hits)
+	assert.Equal(t, int64(3), misses)
+
+	// a different scope does not reuse translations
+	other := NewCacheTranslator(fake, c, CacheScope{Providers: testCacheProviders, Prompt: "hash", TargetLang: "French"}, config.Glossary{})
+	_, err = other.Translate(t.Context(), sub.Batch{Texts: []string{"a"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, fake.calls)
+
+	// with context in the key, a translation is only reused in the same surroundings
+	withContext := NewCacheTranslator(fake, c, CacheScope{Providers: testCacheProviders, Prompt: "hash", TargetLang: "German", Context: true}, config.Glossary{})
+	_, err = withContext.Translate(t.Context(), sub.Batch{Texts: []string{"a"}, After: []string{"b"}})
+	require.NoError(t, err)
+	_, err = withContext.Translate(t.Context(), sub.Batch{Texts: []string{"a"}, After: []string{"c"}})
+	require.NoError(t, err)
+	_, err = withContext.Translate(t.Context(), sub.Batch{Texts: []string{"a"}, After: []string{"b"}})
+	require.NoError(t, err)
+	assert.Equal(t, 5, fake.calls)
+}
+
+func TestCacheTranslatorError(t *testing.T) {
+	c, err := cache.Open(filepath.Join(t.TempDir(), "translations.db"))
+	require.NoError(t, err)
+	defer c.Close()
+
+	fake := &fakeTranslator{errs: []error{sub.ErrCountMismatch}}
+	ct := NewCacheTranslator(fake, c, CacheScope{Providers: testCacheProviders}, config.Glossary{})
+
+	_, err = ct.Translate(t.Context(), sub.Batch{Texts: []string{"a"}})
+	assert.ErrorIs(t, err, sub.ErrCountMismatch)
+
+	// failed translations are not cached
+	_, err = ct.Translate(t.Context(), sub.Batch{Texts: []string{"a"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, fake.calls)
+}
+
+func TestCacheTranslatorProducer(t *testing.T) {
+	c, err := cache.Open(filepath.Join(t.TempDir(), "translations.db"))
+	require.NoError(t, err)
+	defer c.Close()
+
+	primary := &fakeTranslator{errs: []error{errors.New("unavailable")}}
+	ft := newFallbackTranslator([]string{"openai", "claude"}, []sub.Translator{primary, &fakeTranslator{}})
+	_, err = NewCacheTranslator(ft, c, CacheScope{Providers: testCacheProviders}, config.Glossary{}).Translate(t.Context(), sub.Batch{Texts: []string{"a"}})
+	require.NoError(t, err)
+
+	// the translation is cached for the fallback that made it
+	fake := &fakeTranslator{}
+	_, err = NewCacheTranslator(fake, c, CacheScope{Providers: testCacheProviders[:1]}, config.Glossary{}).Translate(t.Context(), sub.Batch{Texts: []string{"a"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, fake.calls)
+	_, err = NewCacheTranslator(fake, c, CacheScope{Providers: testCacheProviders[1:]}, config.Glossary{}).Translate(t.Context(), sub.Batch{Texts: []string{"a"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, fake.calls)
+}
+
+func TestCacheTranslatorRejected(t *testing.T) {
+	c, err := cache.Open(filepath.Join(t.TempDir(), "translations.db"))
+	require.NoError(t, err)
+	defer c.Close()
+
+	fake := &fakeTranslator{responses: [][]string{
+		{"Hallo Frodo", "Halt", "<i>Tschüss</i>"},
+		{"Hallo 佛罗多", "<i>Halt</i>"},
+	}}
+	glossary := config.Glossary{Terms: map[string]string{"Frodo": "佛罗多"}}
+	ct := NewCacheTranslator(fake, c, CacheScope{Providers: testCacheProviders}, glossary)
+
+	batch := sub.Batch{Texts: []string{"Hello Frodo", "<i>Stop</i>", "<i>Bye</i>"}}
+	_, err = ct.Translate(t.Context(), batch)
+	require.NoError(t, err)
+
+	// the translations missing a term or their markup are requested again
+	got, err := ct.Translate(t.Context(), batch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hallo 佛罗多", "<i>Halt</i>", "<i>Tschüss</i>"}, got)
+	assert.Equal(t, sub.Batch{Texts: []string{"Hello Frodo", "<i>Stop</i>"}}, fake.batches[1])
+}
+
+func TestCacheProviders(t *testing.T) {
+	cfg := &config.Config{
+		DefaultLLM: "openai",
+		LLMs: map[string]config.LLMProvider{
+			"openai": {API: config.OpenAI, Model: "gpt-4", Fallback: []string{"claude"}},
+			"claude": {API: config.Anthropic, Model: "claude"},
+		},
+	}
+	providers, err := CacheProviders(cfg, "default")
+	require.NoError(t, err)
+	assert.Equal(t, []CacheProvider{{Name: "openai", API: config.OpenAI, Model: "gpt-4"}, {Name: "claude", API: config.Anthropic, Model: "claude"}}, providers)
+
+	_, err = CacheProviders(cfg, "missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package translator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/charleshuang3/subtrans/pkg/sub"
+)
+
+// FallbackTranslator sends a batch to the next provider when the previous one fails after its retries.
+type FallbackTranslator struct {
+	names       []string
+	translators []sub.Translator
+
+	mu    sync.Mutex
+	lines map[string]int // number of lines translated by each provider
+}
+
+func newFallbackTranslator(names []string, translators []sub.Translator) *FallbackTranslator {
+	return &FallbackTranslator{
+		names:       names,
+		translators: translators,
+		lines:       map[string]int{},
+	}
+}
+
+// inputLimit returns the provider with the smallest limit, so every provider can take any batch.
+func (t *FallbackTranslator) inputLimit() sub.Translator {
+	limit := t.translators[0]
+	for _, tr := range t.translators[1:] {
+		if tr.MaxLength() < limit.MaxLength() {
+			limit = tr
+		}
+	}
+	return limit
+}
+
+// outputLimit returns the provider with the smallest output limit, providers without a limit are ignored.
+func (t *FallbackTranslator) outputLimit() sub.Translator {
+	limit := t.translators[0]
+	for _, tr := range t.translators[1:] {
+		if l := tr.MaxOutputLength(); l > 0 && (limit.MaxOutputLength() == 0 || l < limit.MaxOutputLength()) {
+			limit = tr
+		}
+	}
+	return limit
+}
+
+// Length is measured by the provider of MaxLength, so both use the same tokenizer.
+func (t *FallbackTranslator) Length(text string) int {
+	return t.inputLimit().Length(text)
+}
+
+func (t *FallbackTranslator) MaxLength() int {
+	return t.inputLimit().MaxLength()
+}
+
+// OutputLength is measured by the provider of MaxOutputLength, so both use the same tokenizer.
+func (t *FallbackTranslator) OutputLength(text string) int {
+	return t.outputLimit().OutputLength(text)
+}
+
+func (t *FallbackTranslator) MaxOutputLength() int {
+	return t.outputLimit().MaxOutputLength()
+}
+
+func (t *FallbackTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	var err error
+	for i, tr := range t.translators {
+		var translations []string
+		translations, err = tr.Translate(ctx, batch)
+		if err == nil {
+			setProducer(ctx, t.names[i])
+			log.Printf("%d lines translated by %s: %q", len(batch.Texts), t.names[i], firstAndLast(batch.Texts))
+			t.mu.Lock()
+			t.lines[t.names[i]] += len(batch.Texts)
+			t.mu.Unlock()
+			return translations, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if len(batch.Texts) > 1 && !errors.Is(err, errNoToolCall) &&
+			(errors.Is(err, sub.ErrCountMismatch) || errors.Is(err, sub.ErrMalformedResponse)) {
+			// the provider works, the caller splits the batch before another provider is tried
+			return nil, err
+		}
+		if i+1 < len(t.translators) {
+			log.Printf("Provider %s failed, falling back to %s: %v", t.names[i], t.names[i+1], err)
+		}
+	}
+	return nil, fmt.Errorf("all providers failed, last error: %w", err)
+}
+
+// Summary describes how many lines each provider translated.
+func (t *FallbackTranslator) Summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, len(t.names))
+	for i, name := range t.names {
+		parts[i] = fmt.Sprintf("%s %d", name, t.lines[name])
+	}
+	return "Lines translated per provider: " + strings.Join(parts, ", ")
+}
+
+// firstAndLast returns the first and the last text, enough to find the lines of a batch in the file.
+func firstAndLast(texts []string) []string {
+	if len(texts) <= 2 {
+		return texts
+	}
+	return []string{texts[0], texts[len(texts)-1]}
+}
@@ -0,0 +1,141 @@
+package translator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type limitTranslator struct {
+	fakeTranslator
+	maxLength       int
+	maxOutputLength int
+	// lengthFactor multiplies the lengths of fakeTranslator, like a tokenizer counting differently
+	lengthFactor int
+}
+
+func (l *limitTranslator) Length(text string) int { return max(l.lengthFactor, 1) * len(text) }
+
+func (l *limitTranslator) OutputLength(text string) int { return max(l.lengthFactor, 1) * len(text) }
+
+func (l *limitTranslator) MaxLength() int { return l.maxLength }
+
+func (l *limitTranslator) MaxOutputLength() int { return l.maxOutputLength }
+
+func TestFallbackTranslator(t *testing.T) {
+	quota := errors.New("quota exceeded")
+	primary := &fakeTranslator{errs: []error{quota}}
+	secondary := &fakeTranslator{}
+	ft := newFallbackTranslator([]string{"openai", "claude"}, []sub.Translator{primary, secondary})
+
+	got, err := ft.Translate(t.Context(), sub.Batch{Texts: []string{"a", "b"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, got)
+	assert.Equal(t, 1, primary.calls)
+	assert.Equal(t, 1, secondary.calls)
+
+	// the primary provider is tried first for every batch
+	_, err = ft.Translate(t.Context(), sub.Batch{Texts: []string{"c"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, primary.calls)
+	assert.Equal(t, 1, secondary.calls)
+
+	assert.Equal(t, "Lines translated per provider: openai 1, claude 2", ft.Summary())
+}
+
+func TestFallbackTranslatorAllFail(t *testing.T) {
+	primary := &fakeTranslator{errs: []error{errors.New("quota exceeded")}}
+	secondary := &fakeTranslator{errs: []error{sub.ErrCountMismatch}}
+	ft := newFallbackTranslator([]string{"openai", "claude"}, []sub.Translator{primary, secondary})
+
+	_, err := ft.Translate(t.Context(), sub.Batch{Texts: []string{"a", "b"}})
+	// the last error still lets the batch be split
+	assert.ErrorIs(t, err, sub.ErrCountMismatch)
+}
+
+func TestFallbackTranslatorResponseErrors(t *testing.T) {
+	for _, respErr := range []error{sub.ErrCountMismatch, sub.ErrMalformedResponse} {
+		primary := &fakeTranslator{errs: []error{respErr}}
+		secondary := &fakeTranslator{}
+		ft := newFallbackTranslator([]string{"openai", "claude"}, []sub.Translator{primary, secondary})
+
+		_, err := ft.Translate(t.Context(), sub.Batch{Texts: []string{"a", "b"}})
+		// the batch is split before another provider is tried
+		assert.ErrorIs(t, err, respErr)
+		assert.Equal(t, 0, secondary.calls)
+
+		// a single text can not be split, another provider is tried
+		primary.errs = []error{nil, respErr}
+		got, err := ft.Translate(t.Context(), sub.Batch{Texts: []string{"a"}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a"}, got)
+		assert.Equal(t, 1, secondary.calls)
+	}
+
+	// a refusal without the tool call goes to the next provider, splitting would not help
+	refusal := fmt.Errorf("%w: %w", sub.ErrMalformedResponse, errNoToolCall)
+	primary := &fakeTranslator{errs: []error{refusal}}
+	secondary := &fakeTranslator{}
+	ft := newFallbackTranslator([]string{"openai", "claude"}, []sub.Translator{primary, secondary})
+	_, err := ft.Translate(t.Context(), sub.Batch{Texts: []string{"a", "b"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, secondary.calls)
+}
+
+func TestFallbackTranslatorCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	primary := &fakeTranslator{errs: []error{context.Canceled}}
+	secondary := &fakeTranslator{}
+	ft := newFallbackTranslator([]string{"openai", "claude"}, []sub.Translator{primary, secondary})
+
+	_, err := ft.Translate(ctx, sub.Batch{Texts: []string{"a"}})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, secondary.calls)
+}
+
+func TestFallbackTranslatorMaxLength(t *testing.T) {
+	ft := newFallbackTranslator([]string{"a", "b"}, []sub.Translator{&limitTranslator{maxLength: 100}, &limitTranslator{maxLength: 40, lengthFactor: 2}})
+	assert.Equal(t, 40, ft.MaxLength())
+	// lengths are measured by the provider of the limit
+	assert.Equal(t, 10, ft.Length("Hello"))
+	assert.Equal(t, 0, ft.MaxOutputLength())
+	assert.Equal(t, 5, ft.OutputLength("Hello"))
+
+	ft = newFallbackTranslator([]string{"a", "b", "c"}, []sub.Translator{
+		&limitTranslator{maxOutputLength: 0},
+		&limitTranslator{maxOutputLength: 300, lengthFactor: 3},
+		&limitTranslator{maxOutputLength: 500},
+	})
+	assert.Equal(t, 300, ft.MaxOutputLength())
+	assert.Equal(t, 15, ft.OutputLength("Hello"))
+}
+
+func TestNewLLMTranslatorFallback(t *testing.T) {
+	cfg := &config.Config{
+		DefaultLLM: "openai",
+		LLMs: map[string]config.LLMProvider{
+			"openai": {API: config.OpenAI, APIKey: "key", Model: "gpt-4o", MaxTokens: 1000, Fallback: []string{"claude"}},
+			"claude": {API: config.Anthropic, APIKey: "key", Model: "claude-sonnet-4-5", MaxTokens: 500},
+		},
+	}
+
+	tr, err := NewLLMTranslator(cfg, "default", "default", true, nil)
+	require.NoError(t, err)
+	ft, ok := tr.(*FallbackTranslator)
+	require.True(t, ok)
+	assert.Equal(t, []string{"openai", "claude"}, ft.names)
+	// the smaller context of claude, less the prompt
+	assert.Equal(t, ft.translators[1].MaxLength(), ft.MaxLength())
+	assert.Less(t, ft.MaxLength(), 475)
+
+	tr, err = NewLLMTranslator(cfg, "default", "claude", true, nil)
+	require.NoError(t, err)
+	assert.IsType(t, &missingTranslator{}, tr)
+}
@@ -2,14 +2,18 @@ package translator
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 
+	"cloud.google.com/go/auth/credentials"
 	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
 	"google.golang.org/genai"
 )
 
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
 type GeminiTranslator struct {
+	budget
 	Config     *config.Config
 	Provider   config.LLMProvider
 	client     *genai.Client
@@ -18,15 +22,16 @@ type GeminiTranslator struct {
 }
 
 func newGeminiTranslator(cfg *config.Config, provider config.LLMProvider, promptKey string, dryRun bool) (*GeminiTranslator, error) {
-	promptTmpl, err := getPromptTmpl(cfg, promptKey)
+	promptTmpl, err := PromptTemplate(cfg, promptKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get prompt template: %w", err)
 	}
 
-	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
-		APIKey:  provider.APIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	clientConfig, err := geminiClientConfig(provider)
+	if err != nil {
+		return nil, err
+	}
+	client, err := genai.NewClient(context.Background(), clientConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize Gemini client: %w", err)
 	}
@@ -37,18 +42,43 @@ func newGeminiTranslator(cfg *config.Config, provider config.LLMProvider, prompt
 		client:     client,
 		promptTmpl: promptTmpl,
 		dryRun:     dryRun,
+		budget:     newBudget(cfg, provider, promptTmpl),
 	}, nil
 }
 
-func (t *GeminiTranslator) Length(text string) int {
-	return tokenCount(text)
-}
+func geminiClientConfig(provider config.LLMProvider) (*genai.ClientConfig, error) {
+	httpOptions := genai.HTTPOptions{BaseURL: provider.APIURL}
+	vertex := provider.Vertex
+	if vertex == nil {
+		return &genai.ClientConfig{
+			APIKey:      provider.APIKey,
+			Backend:     genai.BackendGeminiAPI,
+			HTTPOptions: httpOptions,
+		}, nil
+	}
 
-func (t *GeminiTranslator) MaxLength() int {
-	return int(float64(t.Provider.MaxTokens) * 0.95)
+	clientConfig := &genai.ClientConfig{
+		Backend:     genai.BackendVertexAI,
+		Project:     vertex.Project,
+		Location:    vertex.Location,
+		HTTPOptions: httpOptions,
+	}
+	if vertex.CredentialsFile != "" {
+		// without a file the client finds the application default credentials
+		creds, err := credentials.DetectDefault(&credentials.DetectOptions{
+			Scopes:          []string{cloudPlatformScope},
+			CredentialsFile: vertex.CredentialsFile,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Vertex AI credentials: %w", err)
+		}
+		clientConfig.Credentials = creds
+	}
+	return clientConfig, nil
 }
 
-func (t *GeminiTranslator) Translate(texts []string) ([]string, error) {
+func (t *GeminiTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	texts := batch.Texts
 	if t.dryRun {
 		return make([]string, len(texts)), nil
 	}
@@ -56,22 +86,30 @@ func (t *GeminiTranslator) Translate(texts []string) ([]string, error) {
 		return []string{}, nil
 	}
 
-	prompt, err := toPrompt(t.promptTmpl, t.Config.TargetLang, texts)
+	prompt, err := toPrompt(t.promptTmpl, t.Config.TargetLang, t.Config.Glossary, batch)
 	if err != nil {
 		return texts, err
 	}
 
-	ctx := context.Background()
-
 	generateConfig := &genai.GenerateContentConfig{
 		ResponseMIMEType:   "application/json",
 		ResponseJsonSchema: translationResponseJSONSchema,
+		MaxOutputTokens:    int32(t.Provider.MaxOutputTokens),
 	}
 
 	resp, err := t.client.Models.GenerateContent(ctx, t.Provider.Model, genai.Text(prompt), generateConfig)
 	if err != nil {
 		return texts, err
 	}
+	if usage := resp.UsageMetadata; usage != nil {
+		reportUsage(ctx, Usage{
+			PromptTokens: int64(usage.PromptTokenCount + usage.ToolUsePromptTokenCount),
+			// thoughts are billed as output but not counted in the candidates
+			CompletionTokens: int64(usage.CandidatesTokenCount + usage.ThoughtsTokenCount),
+			CachedTokens:     int64(usage.CachedContentTokenCount),
+			ReasoningTokens:  int64(usage.ThoughtsTokenCount),
+		})
+	}
 
 	if len(resp.Candidates) == 0 {
 		return texts, fmt.Errorf("no completion choices returned from Gemini API")
@@ -82,14 +120,5 @@ func (t *GeminiTranslator) Translate(texts []string) ([]string, error) {
 		return texts, fmt.Errorf("empty response from Gemini API")
 	}
 
-	var result TranslationResponse
-	if err := json.Unmarshal([]byte(content.Text), &result); err != nil {
-		return texts, fmt.Errorf("failed to unmarshal translation response: %w", err)
-	}
-
-	if len(result.Translations) == len(texts) {
-		return result.Translations, nil
-	}
-
-	return texts, fmt.Errorf("translation count mismatch: got %d translations for %d input texts", len(result.Translations), len(texts))
+	return parseTranslationResponse(content.Text, texts)
 }
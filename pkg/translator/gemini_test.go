@@ -0,0 +1,94 @@
+package translator
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeServiceAccount writes a service account key whose tokens are issued by tokenURL.
+func writeServiceAccount(t *testing.T, tokenURL string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	data, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "test-project",
+		"private_key_id": "key-1",
+		"private_key":    string(keyPEM),
+		"client_email":   "subtrans@test-project.iam.gserviceaccount.com",
+		"token_uri":      tokenURL,
+	})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func TestGeminiVertex(t *testing.T) {
+	var request map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			json.NewEncoder(w).Encode(map[string]any{"access_token": "vertex-token", "token_type": "Bearer", "expires_in": 3600})
+			return
+		}
+
+		assert.Equal(t, "/v1beta1/projects/test-project/locations/europe-west4/publishers/google/models/gemini-test:generateContent", r.URL.Path)
+		assert.Equal(t, "Bearer vertex-token", r.Header.Get("Authorization"))
+		assert.Empty(t, r.Header.Get("X-Goog-Api-Key"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"candidates": []any{map[string]any{
+				"content": map[string]any{"role": "model", "parts": []any{map[string]any{"text": `{"translations":[{"id":1,"text":"Hola"}]}`}}},
+			}},
+			"usageMetadata": map[string]any{"promptTokenCount": 12, "candidatesTokenCount": 5},
+		})
+	}))
+	defer server.Close()
+
+	provider := config.LLMProvider{
+		API:       config.Gemini,
+		APIURL:    server.URL,
+		Model:     "gemini-test",
+		MaxTokens: 1000,
+		Vertex: &config.Vertex{
+			Project:         "test-project",
+			Location:        "europe-west4",
+			CredentialsFile: writeServiceAccount(t, server.URL+"/token"),
+		},
+	}
+	tr, err := newGeminiTranslator(&config.Config{TargetLang: "Spanish"}, provider, "default", false)
+	require.NoError(t, err)
+
+	recorder := NewRecorder(nil)
+	got, err := newUsageTranslator(tr, "vertex", "gemini-test", recorder).Translate(t.Context(), sub.Batch{Texts: []string{"Hello"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hola"}, got)
+	assert.Equal(t, Usage{PromptTokens: 12, CompletionTokens: 5}, recorder.Report().Usage)
+	assert.Equal(t, "application/json", request["generationConfig"].(map[string]any)["responseMimeType"])
+}
+
+func TestGeminiVertexCredentialsFileMissing(t *testing.T) {
+	provider := config.LLMProvider{
+		API:    config.Gemini,
+		Model:  "gemini-test",
+		Vertex: &config.Vertex{Project: "p", Location: "us-central1", CredentialsFile: filepath.Join(t.TempDir(), "missing.json")},
+	}
+	_, err := newGeminiTranslator(&config.Config{TargetLang: "Spanish"}, provider, "default", false)
+	assert.ErrorContains(t, err, "failed to read Vertex AI credentials")
+}
@@ -0,0 +1,169 @@
+package translator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+)
+
+// unspacedScripts write words without spaces between them, their terms are found inside longer runs of letters.
+var unspacedScripts = []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai}
+
+// isWordRune reports whether r is part of a word of a script separating words with spaces.
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') && !unicode.In(r, unspacedScripts...)
+}
+
+// containsTerm reports whether term appears in text ignoring case, as a whole word unless it is written in a script
+// without spaces, so "Ann" is not found in "announcement".
+func containsTerm(text, term string) bool {
+	if term == "" {
+		return false
+	}
+	text, term = strings.ToLower(text), strings.ToLower(term)
+	first, _ := utf8.DecodeRuneInString(term)
+	last, _ := utf8.DecodeLastRuneInString(term)
+	for i := 0; i <= len(text); {
+		j := strings.Index(text[i:], term)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(term)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !(isWordRune(before) && isWordRune(first)) && !(isWordRune(last) && isWordRune(after)) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(text[start:])
+		i = start + size
+	}
+	return false
+}
+
+// relevantGlossary returns the terms and keep terms of glossary appearing in any of texts, sorted.
+func relevantGlossary(glossary config.Glossary, texts []string) (terms []string, keep []string) {
+	appears := func(term string) bool {
+		return slices.ContainsFunc(texts, func(text string) bool { return containsTerm(text, term) })
+	}
+
+	for source := range glossary.Terms {
+		if appears(source) {
+			terms = append(terms, source)
+		}
+	}
+	for _, term := range glossary.Keep {
+		if appears(term) {
+			keep = append(keep, term)
+		}
+	}
+	slices.Sort(terms)
+	slices.Sort(keep)
+	return terms, keep
+}
+
+// toGlossarySection lists the glossary entries relevant to texts, it is empty if there are none.
+func toGlossarySection(glossary config.Glossary, texts []string) string {
+	terms, keep := relevantGlossary(glossary, texts)
+	if len(terms) == 0 && len(keep) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	if len(terms) > 0 {
+		sb.WriteString("Glossary, always translate these terms as given:\n")
+		for _, source := range terms {
+			fmt.Fprintf(&sb, "%s => %s\n", source, glossary.Terms[source])
+		}
+	}
+	if len(keep) > 0 {
+		sb.WriteString("Keep these terms untranslated:\n")
+		for _, term := range keep {
+			fmt.Fprintf(&sb, "%s\n", term)
+		}
+	}
+	return sb.String()
+}
+
+// glossaryViolations returns the required terms missing from the translation of text.
+func glossaryViolations(glossary config.Glossary, text, translation string) []string {
+	terms, keep := relevantGlossary(glossary, []string{text})
+	missing := []string{}
+	for _, source := range terms {
+		if target := glossary.Terms[source]; !containsTerm(translation, target) {
+			missing = append(missing, target)
+		}
+	}
+	for _, term := range keep {
+		if !containsTerm(translation, term) {
+			missing = append(missing, term)
+		}
+	}
+	return missing
+}
+
+// glossaryTranslator checks that translations use the glossary terms, lines missing a term are requested again
+// up to glossary.Retries times and logged if they still miss it.
+type glossaryTranslator struct {
+	sub.Translator
+	glossary config.Glossary
+}
+
+func newGlossaryTranslator(t sub.Translator, glossary config.Glossary) *glossaryTranslator {
+	return &glossaryTranslator{
+		Translator: t,
+		glossary:   glossary,
+	}
+}
+
+func (t *glossaryTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	translations, err := t.Translator.Translate(ctx, batch)
+	if err != nil {
+		return translations, err
+	}
+
+	for attempt := 1; attempt <= t.glossary.Retries; attempt++ {
+		violating := t.violating(batch.Texts, translations)
+		if len(violating) == 0 {
+			return translations, nil
+		}
+
+		log.Printf("Translations of %d lines miss glossary terms, requesting them again (attempt %d/%d)", len(violating), attempt, t.glossary.Retries)
+		request := sub.Batch{Before: batch.Before, After: batch.After}
+		for _, i := range violating {
+			request.Texts = append(request.Texts, batch.Texts[i])
+		}
+		retranslations, err := t.Translator.Translate(ctx, request)
+		if err != nil {
+			// the first translations are still usable
+			log.Printf("Warning: failed to request lines missing glossary terms again: %v", err)
+			break
+		}
+		for j, i := range violating {
+			translations[i] = retranslations[j]
+		}
+	}
+
+	for _, i := range t.violating(batch.Texts, translations) {
+		log.Printf("Warning: translation misses glossary terms %q: %q => %q",
+			glossaryViolations(t.glossary, batch.Texts[i], translations[i]), batch.Texts[i], translations[i])
+	}
+	return translations, nil
+}
+
+// violating returns the indices of texts whose translations miss glossary terms.
+func (t *glossaryTranslator) violating(texts, translations []string) []int {
+	violating := []int{}
+	for i, text := range texts {
+		if len(glossaryViolations(t.glossary, text, translations[i])) > 0 {
+			violating = append(violating, i)
+		}
+	}
+	return violating
+}
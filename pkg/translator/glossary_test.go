@@ -0,0 +1,118 @@
+package translator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testGlossary = config.Glossary{
+	Terms: map[string]string{"Frodo": "佛罗多", "Shire": "夏尔"},
+	Keep:  []string{"Gandalf"},
+}
+
+func TestToGlossarySection(t *testing.T) {
+	assert.Equal(t, "", toGlossarySection(testGlossary, []string{"Hello"}))
+	assert.Equal(t, "Glossary, always translate these terms as given:\nFrodo => 佛罗多\nKeep these terms untranslated:\nGandalf\n",
+		toGlossarySection(testGlossary, []string{"Hello frodo", "gandalf!"}))
+
+	got, err := toPrompt("$SUBTITLES$\n$GLOSSARY$---", "Chinese", testGlossary, sub.Batch{Texts: []string{"The Shire"}})
+	require.NoError(t, err)
+	assert.Equal(t, "[{\"id\":1,\"text\":\"The Shire\"}]\nGlossary, always translate these terms as given:\nShire => 夏尔\n---", got)
+}
+
+func TestGlossaryViolations(t *testing.T) {
+	assert.Empty(t, glossaryViolations(testGlossary, "Frodo and Gandalf", "佛罗多和Gandalf"))
+	assert.Equal(t, []string{"佛罗多", "Gandalf"}, glossaryViolations(testGlossary, "Frodo and Gandalf", "弗罗多和甘道夫"))
+	assert.Empty(t, glossaryViolations(testGlossary, "Hello", "你好"))
+
+	// terms inside longer words do not count
+	glossary := config.Glossary{Terms: map[string]string{"Ann": "安"}, Keep: []string{"Ed"}}
+	assert.Empty(t, glossaryViolations(glossary, "The announcement was edited.", "公告已编辑。"))
+	assert.Equal(t, "", toGlossarySection(glossary, []string{"The announcement was edited."}))
+	assert.Equal(t, []string{"安", "Ed"}, glossaryViolations(glossary, "Ann met Ed.", "Anne和Eddie见面了。"))
+	assert.Empty(t, glossaryViolations(glossary, "Ann met Ed.", "安和Ed见面了。"))
+}
+
+func TestContainsTerm(t *testing.T) {
+	tests := []struct {
+		text string
+		term string
+		want bool
+	}{
+		{"Hello Frodo!", "frodo", true},
+		{"The announcement", "Ann", false},
+		{"Anne's annals, Ann.", "ann", true},
+		{"Mr. Baggins", "Mr.", true},
+		{"你好佛罗多", "佛罗多", true},
+		{"安和Ed见面了", "Ed", true},
+		{"Eddie", "Ed", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			assert.Equal(t, tt.want, containsTerm(tt.text, tt.term))
+		})
+	}
+}
+
+func TestGlossaryTranslator(t *testing.T) {
+	texts := []string{"Hello Frodo", "Bye", "Gandalf"}
+
+	tests := []struct {
+		name        string
+		retries     int
+		responses   [][]string
+		errs        []error
+		want        []string
+		wantBatches int
+	}{
+		{
+			name:        "no violations",
+			retries:     1,
+			responses:   [][]string{{"你好佛罗多", "再见", "Gandalf"}},
+			want:        []string{"你好佛罗多", "再见", "Gandalf"},
+			wantBatches: 1,
+		},
+		{
+			name:        "violations fixed by retry",
+			retries:     2,
+			responses:   [][]string{{"你好弗罗多", "再见", "甘道夫"}, {"你好佛罗多", "甘道夫"}, {"Gandalf"}},
+			want:        []string{"你好佛罗多", "再见", "Gandalf"},
+			wantBatches: 3,
+		},
+		{
+			name:        "violations only logged",
+			responses:   [][]string{{"你好弗罗多", "再见", "Gandalf"}},
+			want:        []string{"你好弗罗多", "再见", "Gandalf"},
+			wantBatches: 1,
+		},
+		{
+			name:        "failed retry keeps first translations",
+			retries:     2,
+			responses:   [][]string{{"你好弗罗多", "再见", "甘道夫"}},
+			errs:        []error{nil, errors.New("boom")},
+			want:        []string{"你好弗罗多", "再见", "甘道夫"},
+			wantBatches: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			glossary := testGlossary
+			glossary.Retries = tt.retries
+			scripted := &fakeTranslator{responses: tt.responses, errs: tt.errs}
+			gt := newGlossaryTranslator(scripted, glossary)
+
+			got, err := gt.Translate(t.Context(), sub.Batch{Texts: texts, After: []string{"next"}})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			require.Len(t, scripted.batches, tt.wantBatches)
+			if tt.wantBatches > 1 {
+				assert.Equal(t, sub.Batch{Texts: []string{"Hello Frodo", "Gandalf"}, After: []string{"next"}}, scripted.batches[1])
+			}
+		})
+	}
+}
@@ -1,56 +1,117 @@
 package translator
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"slices"
 	"strings"
 
 	"github.com/charleshuang3/subtrans/pkg/config"
 	"github.com/charleshuang3/subtrans/pkg/sub"
 	"github.com/google/jsonschema-go/jsonschema"
-	"github.com/tiktoken-go/tokenizer"
 )
 
 const (
-	defaultPromptTmpl = `Translate the following subtitle texts to $TARGET_LANG$ line by line. Return a JSON object with a "translations" array containing the translated texts in the same order:
+	defaultPromptTmpl = `Translate the following subtitle texts to $TARGET_LANG$ line by line. Return a JSON object with a "translations" array containing the id and the translated text of every subtitle text:
 
 Return format:
 {
-  "translations": ["translation1", "translation2", ...]
+  "translations": [{"id": 1, "text": "translation1"}, {"id": 2, "text": "translation2"}, ...]
 }
   
 Subtitle texts:
 $SUBTITLES$
+`
+
+	// sentenceNote is added to the prompt when texts are sentences spread over several cues.
+	sentenceNote = `Some texts are one sentence spread over several subtitle cues separated by <cue/>. Translate each of them as a whole sentence with natural word order, then split the translation with <cue/> into exactly as many parts as the source has, so every part can be shown at the time of its source cue.
+`
+
+	// multiLangNote is added to the prompt of multi-language requests.
+	multiLangNote = `Every text has a lang field naming the language to translate it to. Translate each text only to its own language and return the translation with the id of the text.
+`
+
+	// markupNote is added to the prompt when texts contain markup placeholders.
+	markupNote = `Some texts contain placeholders like ⟦1⟧ standing for formatting. Keep every placeholder unchanged and exactly once in the translation, next to the words that correspond to the source words next to it, and keep the line breaks of each text.
 `
 )
 
-func NewLLMTranslator(cfg *config.Config, promptKey, llmProvider string, dryRun bool) (sub.Translator, error) {
-	var provider config.LLMProvider
-	var err error
+// NewLLMTranslator returns the translator of llmProvider with its fallbacks, requests are recorded to recorder unless
+// it is nil.
+func NewLLMTranslator(cfg *config.Config, promptKey, llmProvider string, dryRun bool, recorder *Recorder) (sub.Translator, error) {
+	provider, err := cfg.ResolveLLM(llmProvider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get LLM provider '%s': %w", llmProvider, err)
+	}
+	name := llmProvider
+	if name == "default" {
+		name = cfg.DefaultLLM
+	}
+	if len(provider.Fallback) == 0 {
+		return newChainTranslator(cfg, name, provider, promptKey, dryRun, recorder)
+	}
 
-	if llmProvider == "default" {
-		provider, err = cfg.GetDefaultLLM()
+	names := append([]string{name}, provider.Fallback...)
+	translators := make([]sub.Translator, len(names))
+	for i, name := range names {
+		p, err := cfg.GetLLM(name)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get default LLM provider: %w", err)
+			return nil, err
 		}
-	} else {
-		provider, err = cfg.GetLLM(llmProvider)
+		translators[i], err = newChainTranslator(cfg, name, p, promptKey, dryRun, recorder)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get LLM provider '%s': %w", llmProvider, err)
+			return nil, fmt.Errorf("failed to create LLM provider '%s': %w", name, err)
 		}
 	}
+	return newFallbackTranslator(names, translators), nil
+}
+
+// newChainTranslator returns the translator of provider with usage recording, rate limits, retries, requests of
+// missing translations and glossary checks.
+func newChainTranslator(cfg *config.Config, name string, provider config.LLMProvider, promptKey string, dryRun bool, recorder *Recorder) (sub.Translator, error) {
+	t, err := newProviderTranslator(cfg, provider, promptKey, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if recorder != nil {
+		// every attempt is recorded, failed requests are billed too
+		t = newUsageTranslator(t, name, provider.Model, recorder)
+	}
+	if !dryRun {
+		t = newRateLimitTranslator(t, sharedRateLimiter(name, provider))
+	}
+	t = newRetryTranslator(t, cfg.Retry)
+	t = newMissingTranslator(t)
+	if !cfg.Glossary.Empty() && !dryRun {
+		t = newGlossaryTranslator(t, cfg.Glossary)
+	}
+	if !dryRun {
+		// dry runs return no translations to restore markup into
+		t = newPlaceholderTranslator(t)
+	}
+	return t, nil
+}
 
+func newProviderTranslator(cfg *config.Config, provider config.LLMProvider, promptKey string, dryRun bool) (sub.Translator, error) {
 	switch provider.API {
 	case config.OpenAI:
 		return newOpenAITranslator(cfg, provider, promptKey, dryRun), nil
 	case config.Gemini:
 		return newGeminiTranslator(cfg, provider, promptKey, dryRun)
+	case config.Anthropic:
+		return newAnthropicTranslator(cfg, provider, promptKey, dryRun)
+	case config.Ollama:
+		return newOllamaTranslator(cfg, provider, promptKey, dryRun)
 	default:
 		return nil, fmt.Errorf("Unsupported API type: %s", provider.API)
 	}
 }
 
-func getPromptTmpl(cfg *config.Config, promptKey string) (string, error) {
+// PromptTemplate returns the prompt template for promptKey, "default" falls back to the built-in template.
+func PromptTemplate(cfg *config.Config, promptKey string) (string, error) {
 	if s, ok := cfg.Prompts[promptKey]; ok {
 		return s, nil
 	}
@@ -60,27 +121,202 @@ func getPromptTmpl(cfg *config.Config, promptKey string) (string, error) {
 	return "", fmt.Errorf("prompt %q not found from config", promptKey)
 }
 
-func toPrompt(promptTmpl string, lang string, texts []string) (string, error) {
-	textsJSON, err := json.Marshal(texts)
+func toPrompt(promptTmpl string, lang string, glossary config.Glossary, batch sub.Batch) (string, error) {
+	textsJSON, err := marshalJSON(toEntries(batch.Texts))
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal input texts: %w", err)
 	}
 
+	contextSection, err := toContextSection(batch)
+	if err != nil {
+		return "", err
+	}
+
 	s := strings.ReplaceAll(promptTmpl, "$TARGET_LANG$", lang)
-	return strings.ReplaceAll(s, "$SUBTITLES$", string(textsJSON)), nil
+	s = strings.ReplaceAll(s, "$SUBTITLES$", string(textsJSON))
+	if slices.ContainsFunc(batch.Texts, hasLang) {
+		s += "\n" + multiLangNote
+	}
+	if slices.ContainsFunc(batch.Texts, hasPlaceholder) {
+		s += "\n" + markupNote
+	}
+	if slices.ContainsFunc(batch.Texts, func(text string) bool { return strings.Contains(text, sub.CueSeparator) }) {
+		s += "\n" + sentenceNote
+	}
+	s = withSection(s, "$GLOSSARY$", toGlossarySection(glossary, batch.Texts))
+	return withSection(s, "$CONTEXT$", contextSection), nil
+}
+
+// withSection replaces placeholder with section, or appends a non-empty section if there is no placeholder.
+func withSection(s, placeholder, section string) string {
+	if strings.Contains(s, placeholder) {
+		return strings.ReplaceAll(s, placeholder, section)
+	}
+	if section == "" {
+		return s
+	}
+	return s + "\n" + section
+}
+
+// marshalJSON is json.Marshal without escaping <, > and &, which keeps markup tags readable in prompts.
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// toContextSection describes the read-only context lines of batch, it is empty if there are none.
+func toContextSection(batch sub.Batch) (string, error) {
+	if len(batch.Before) == 0 && len(batch.After) == 0 {
+		return "", nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Surrounding dialogue for reference only, do not translate it and do not include it in \"translations\":\n")
+	if len(batch.Before) > 0 {
+		beforeJSON, err := marshalJSON(batch.Before)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal previous lines: %w", err)
+		}
+		sb.WriteString("Previous lines (with their translations if available):\n")
+		sb.Write(beforeJSON)
+		sb.WriteString("\n")
+	}
+	if len(batch.After) > 0 {
+		afterJSON, err := marshalJSON(batch.After)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal following lines: %w", err)
+		}
+		sb.WriteString("Following lines:\n")
+		sb.Write(afterJSON)
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// TranslationEntry is a text of a request or its translation in the response, ids number the texts of a request
+// from 1 so translations are matched by id instead of by position.
+type TranslationEntry struct {
+	ID   int    `json:"id"`
+	Text string `json:"text"`
 }
 
 type TranslationResponse struct {
-	Translations []string `json:"translations"`
+	Translations []TranslationEntry `json:"translations"`
 }
 
-var (
-	translationResponseJSONSchema, _ = jsonschema.For[TranslationResponse](&jsonschema.ForOptions{})
-	encoder, _                       = tokenizer.Get(tokenizer.Cl100kBase)
-)
+// langEntry is a text of a multi-language request with the language to translate it to.
+type langEntry struct {
+	ID   int    `json:"id"`
+	Lang string `json:"lang"`
+	Text string `json:"text"`
+}
+
+// toEntry returns the entry of text in the prompt.
+func toEntry(id int, text string) any {
+	if lang, source, ok := splitLang(text); ok {
+		return langEntry{ID: id, Lang: lang, Text: source}
+	}
+	return TranslationEntry{ID: id, Text: text}
+}
+
+func toEntries(texts []string) []any {
+	entries := make([]any, len(texts))
+	for i, text := range texts {
+		entries[i] = toEntry(i+1, text)
+	}
+	return entries
+}
+
+// missingTranslationsError is returned when a response lacks the translations of some ids, it carries the
+// translations found so the missing ones can be requested on their own.
+type missingTranslationsError struct {
+	translations []string // empty for the missing texts
+	missing      []int    // indexes of the texts without a translation
+}
+
+func (e *missingTranslationsError) Error() string {
+	return fmt.Sprintf("%s: missing translations of %d of %d input texts", sub.ErrCountMismatch, len(e.missing), len(e.translations))
+}
+
+func (e *missingTranslationsError) Unwrap() error {
+	return sub.ErrCountMismatch
+}
 
-// tokenCount is approximate: not all LLMs use cl100k, and tokenCount(a)+tokenCount(b) != tokenCount(a+b).
-func tokenCount(sentence string) int {
-	ids, _, _ := encoder.Encode(sentence)
-	return len(ids)
+// errNoToolCall is returned with sub.ErrMalformedResponse when the model answers without calling the translation
+// tool, e.g. when it refuses the request. Another provider may answer, splitting the batch does not help.
+var errNoToolCall = errors.New("no tool call")
+
+// parseTranslationResponse parses the JSON response of an LLM into one translation per input text. Translations are
+// matched by id, an id missing or repeated with different texts is returned as a *missingTranslationsError. Plain
+// strings, which custom prompts may ask for, are matched by position.
+func parseTranslationResponse(content string, texts []string) ([]string, error) {
+	var result struct {
+		Translations []json.RawMessage `json:"translations"`
+	}
+	if err := json.Unmarshal([]byte(content), &result); err != nil {
+		return texts, fmt.Errorf("%w: failed to unmarshal translation response: %w", sub.ErrMalformedResponse, err)
+	}
+
+	entries := make([]TranslationEntry, len(result.Translations))
+	positional := false
+	for i, raw := range result.Translations {
+		if err := json.Unmarshal(raw, &entries[i].Text); err == nil {
+			positional = true
+			entries[i].ID = i + 1
+		} else if err := json.Unmarshal(raw, &entries[i]); err != nil {
+			return texts, fmt.Errorf("%w: failed to unmarshal translation %d: %w", sub.ErrMalformedResponse, i+1, err)
+		}
+	}
+	if positional && len(entries) != len(texts) {
+		// without ids a dropped line shifts all later ones, nothing can be matched
+		return texts, fmt.Errorf("%w: got %d translations for %d input texts", sub.ErrCountMismatch, len(entries), len(texts))
+	}
+
+	translations := make([]string, len(texts))
+	found := make([]bool, len(texts))
+	ambiguous := make([]bool, len(texts))
+	for _, entry := range entries {
+		i := entry.ID - 1
+		if i < 0 || i >= len(texts) {
+			log.Printf("Warning: ignoring translation of unknown id %d: %q", entry.ID, entry.Text)
+			continue
+		}
+		if found[i] && translations[i] != entry.Text {
+			log.Printf("Warning: id %d translated twice: %q and %q", entry.ID, translations[i], entry.Text)
+			ambiguous[i] = true
+			continue
+		}
+		translations[i] = entry.Text
+		found[i] = true
+	}
+
+	missing := []int{}
+	for i := range texts {
+		if !found[i] || ambiguous[i] {
+			translations[i] = ""
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) > 0 {
+		return translations, &missingTranslationsError{translations: translations, missing: missing}
+	}
+	return translations, nil
 }
+
+// extractJSON returns the JSON object in a free text response, which models often wrap in a code block or surround
+// with explanations.
+func extractJSON(content string) string {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return content
+	}
+	return content[start : end+1]
+}
+
+var translationResponseJSONSchema, _ = jsonschema.For[TranslationResponse](&jsonschema.ForOptions{})
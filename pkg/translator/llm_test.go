@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
 	"github.com/stretchr/testify/require"
 )
 
@@ -70,16 +71,16 @@ func TestLLMTranslator(t *testing.T) {
 				},
 				TargetLang: "简体中文",
 			}
-			translator, err := NewLLMTranslator(&cfg, "default", "default", false)
+			translator, err := NewLLMTranslator(&cfg, "default", "default", false, nil)
 			require.NoError(t, err)
-			got, err := translator.Translate(testInput)
+			got, err := translator.Translate(t.Context(), sub.Batch{Texts: testInput})
 			require.NoError(t, err)
 			require.Equal(t, wantOutput, got)
 		})
 	}
 }
 
-func TestGetPromptTmpl(t *testing.T) {
+func TestPromptTemplate(t *testing.T) {
 	tests := []struct {
 		name      string
 		config    config.Config
@@ -127,7 +128,7 @@ func TestGetPromptTmpl(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := getPromptTmpl(&tt.config, tt.promptKey)
+			got, err := PromptTemplate(&tt.config, tt.promptKey)
 			if tt.wantError {
 				require.Error(t, err)
 				require.Empty(t, got)
@@ -152,22 +153,22 @@ func TestToPrompt(t *testing.T) {
 			promptTmpl: "Translate to $TARGET_LANG$: $SUBTITLES$",
 			lang:       "Spanish",
 			texts:      []string{"Hello", "How are you?"},
-			want:       "Translate to Spanish: [\"Hello\",\"How are you?\"]",
+			want:       "Translate to Spanish: [{\"id\":1,\"text\":\"Hello\"},{\"id\":2,\"text\":\"How are you?\"}]",
 		},
 		{
 			name:       "Default prompt template",
 			promptTmpl: defaultPromptTmpl,
 			lang:       "French",
 			texts:      []string{"Good morning", "See you later"},
-			want: `Translate the following subtitle texts to French. Return a JSON object with a "translations" array containing the translated texts in the same order:
+			want: `Translate the following subtitle texts to French line by line. Return a JSON object with a "translations" array containing the id and the translated text of every subtitle text:
 
 Return format:
 {
-  "translations": ["translation1", "translation2", ...]
+  "translations": [{"id": 1, "text": "translation1"}, {"id": 2, "text": "translation2"}, ...]
 }
   
 Subtitle texts:
-["Good morning","See you later"]
+[{"id":1,"text":"Good morning"},{"id":2,"text":"See you later"}]
 `,
 		},
 		{
@@ -182,21 +183,21 @@ Subtitle texts:
 			promptTmpl: "$TARGET_LANG$ translation: $SUBTITLES$",
 			lang:       "Japanese",
 			texts:      []string{"こんにちは"},
-			want:       "Japanese translation: [\"こんにちは\"]",
+			want:       "Japanese translation: [{\"id\":1,\"text\":\"こんにちは\"}]",
 		},
 		{
 			name:       "Text with special characters",
 			promptTmpl: "Translate to $TARGET_LANG$: $SUBTITLES$",
 			lang:       "Chinese",
 			texts:      []string{"Hello\nworld", "Tab\there", "Quote\"test"},
-			want:       "Translate to Chinese: [\"Hello\\nworld\",\"Tab\\there\",\"Quote\\\"test\"]",
+			want:       "Translate to Chinese: [{\"id\":1,\"text\":\"Hello\\nworld\"},{\"id\":2,\"text\":\"Tab\\there\"},{\"id\":3,\"text\":\"Quote\\\"test\"}]",
 		},
 		{
 			name:       "Template without target lang placeholder",
 			promptTmpl: "Translate: $SUBTITLES$",
 			lang:       "English",
 			texts:      []string{"Hello"},
-			want:       "Translate: [\"Hello\"]",
+			want:       "Translate: [{\"id\":1,\"text\":\"Hello\"}]",
 		},
 		{
 			name:       "Template without subtitles placeholder",
@@ -209,7 +210,73 @@ Subtitle texts:
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := toPrompt(tt.promptTmpl, tt.lang, tt.texts)
+			got, err := toPrompt(tt.promptTmpl, tt.lang, config.Glossary{}, sub.Batch{Texts: tt.texts})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestToPromptContext(t *testing.T) {
+	batch := sub.Batch{
+		Texts:  []string{"How are you?"},
+		Before: []sub.ContextLine{{Text: "Hello", Translation: "Hola"}, {Text: "Good morning"}},
+		After:  []string{"Fine"},
+	}
+	contextSection := `Surrounding dialogue for reference only, do not translate it and do not include it in "translations":
+Previous lines (with their translations if available):
+[{"text":"Hello","translation":"Hola"},{"text":"Good morning"}]
+Following lines:
+["Fine"]
+`
+
+	tests := []struct {
+		name       string
+		promptTmpl string
+		batch      sub.Batch
+		want       string
+	}{
+		{
+			name:       "Context appended",
+			promptTmpl: "Translate to $TARGET_LANG$: $SUBTITLES$",
+			batch:      batch,
+			want:       "Translate to Spanish: [{\"id\":1,\"text\":\"How are you?\"}]\n" + contextSection,
+		},
+		{
+			name:       "Context placeholder",
+			promptTmpl: "Translate to $TARGET_LANG$.\n$CONTEXT$Texts: $SUBTITLES$",
+			batch:      batch,
+			want:       "Translate to Spanish.\n" + contextSection + "Texts: [{\"id\":1,\"text\":\"How are you?\"}]",
+		},
+		{
+			name:       "Only following lines",
+			promptTmpl: "$SUBTITLES$",
+			batch:      sub.Batch{Texts: []string{"Hello"}, After: []string{"Fine"}},
+			want:       "[{\"id\":1,\"text\":\"Hello\"}]\nSurrounding dialogue for reference only, do not translate it and do not include it in \"translations\":\nFollowing lines:\n[\"Fine\"]\n",
+		},
+		{
+			name:       "Markup note",
+			promptTmpl: "$SUBTITLES$",
+			batch:      sub.Batch{Texts: []string{"I ⟦1⟧really⟦2⟧ mean it"}},
+			want:       "[{\"id\":1,\"text\":\"I ⟦1⟧really⟦2⟧ mean it\"}]\n" + markupNote,
+		},
+		{
+			name:       "Sentence note",
+			promptTmpl: "$SUBTITLES$",
+			batch:      sub.Batch{Texts: []string{"I told him that\n<cue/>\nwe were leaving."}},
+			want:       "[{\"id\":1,\"text\":\"I told him that\\n<cue/>\\nwe were leaving.\"}]\n" + sentenceNote,
+		},
+		{
+			name:       "Empty context placeholder",
+			promptTmpl: "$CONTEXT$$SUBTITLES$",
+			batch:      sub.Batch{Texts: []string{"Hello"}},
+			want:       "[{\"id\":1,\"text\":\"Hello\"}]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toPrompt(tt.promptTmpl, "Spanish", config.Glossary{}, tt.batch)
 			require.NoError(t, err)
 			require.Equal(t, tt.want, got)
 		})
@@ -0,0 +1,46 @@
+package translator
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/charleshuang3/subtrans/pkg/sub"
+)
+
+// missingTranslator requests the texts a response has no translation for again on their own, keeping the
+// translations the response has.
+type missingTranslator struct {
+	sub.Translator
+}
+
+func newMissingTranslator(t sub.Translator) *missingTranslator {
+	return &missingTranslator{Translator: t}
+}
+
+func (t *missingTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	translations, err := t.Translator.Translate(ctx, batch)
+	var missingErr *missingTranslationsError
+	if !errors.As(err, &missingErr) || len(missingErr.missing) == len(batch.Texts) {
+		// nothing to keep, the batch is split by the caller
+		return translations, err
+	}
+
+	log.Printf("Response misses translations of %d of %d texts, requesting them again", len(missingErr.missing), len(batch.Texts))
+	// the context lines stay the same
+	request := sub.Batch{Before: batch.Before, After: batch.After}
+	for _, i := range missingErr.missing {
+		request.Texts = append(request.Texts, batch.Texts[i])
+	}
+	// every round requests fewer texts, so the recursion ends
+	results, err := t.Translate(ctx, request)
+	if err != nil {
+		return translations, err
+	}
+
+	translations = missingErr.translations
+	for j, i := range missingErr.missing {
+		translations[i] = results[j]
+	}
+	return translations, nil
+}
@@ -0,0 +1,112 @@
+package translator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTranslationResponse(t *testing.T) {
+	texts := []string{"one", "two", "three"}
+	tests := []struct {
+		name        string
+		content     string
+		want        []string
+		wantMissing []int
+		wantErr     error
+	}{
+		{
+			name:    "matched by id",
+			content: `{"translations":[{"id":2,"text":"zwei"},{"id":1,"text":"eins"},{"id":3,"text":"drei"}]}`,
+			want:    []string{"eins", "zwei", "drei"},
+		},
+		{
+			name:        "dropped line",
+			content:     `{"translations":[{"id":1,"text":"eins"},{"id":3,"text":"drei"}]}`,
+			want:        []string{"eins", "", "drei"},
+			wantMissing: []int{1},
+		},
+		{
+			name:        "merged lines repeat an id",
+			content:     `{"translations":[{"id":1,"text":"eins zwei"},{"id":1,"text":"eins"},{"id":3,"text":"drei"}]}`,
+			want:        []string{"", "", "drei"},
+			wantMissing: []int{0, 1},
+		},
+		{
+			name:    "same translation twice",
+			content: `{"translations":[{"id":1,"text":"eins"},{"id":1,"text":"eins"},{"id":2,"text":"zwei"},{"id":3,"text":"drei"}]}`,
+			want:    []string{"eins", "zwei", "drei"},
+		},
+		{
+			name:    "unknown id ignored",
+			content: `{"translations":[{"id":1,"text":"eins"},{"id":2,"text":"zwei"},{"id":3,"text":"drei"},{"id":9,"text":"neun"}]}`,
+			want:    []string{"eins", "zwei", "drei"},
+		},
+		{
+			name:    "plain strings by position",
+			content: `{"translations":["eins","zwei","drei"]}`,
+			want:    []string{"eins", "zwei", "drei"},
+		},
+		{
+			name:    "plain strings with a dropped line",
+			content: `{"translations":["eins","drei"]}`,
+			want:    texts,
+			wantErr: sub.ErrCountMismatch,
+		},
+		{
+			name:    "not JSON",
+			content: `eins, zwei, drei`,
+			want:    texts,
+			wantErr: sub.ErrMalformedResponse,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTranslationResponse(tt.content, texts)
+			assert.Equal(t, tt.want, got)
+			var missingErr *missingTranslationsError
+			switch {
+			case tt.wantMissing != nil:
+				require.ErrorAs(t, err, &missingErr)
+				assert.Equal(t, tt.wantMissing, missingErr.missing)
+				assert.ErrorIs(t, err, sub.ErrCountMismatch)
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.False(t, errors.As(err, &missingErr))
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMissingTranslator(t *testing.T) {
+	provider := &fakeTranslator{contents: []string{
+		`{"translations":[{"id":1,"text":"eins"},{"id":3,"text":"drei"}]}`,
+		`{"translations":[{"id":1,"text":"zwei"}]}`,
+	}}
+	tr := newMissingTranslator(provider)
+
+	batch := sub.Batch{Texts: []string{"one", "two", "three"}, After: []string{"four"}}
+	got, err := tr.Translate(t.Context(), batch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eins", "zwei", "drei"}, got)
+
+	require.Len(t, provider.batches, 2)
+	assert.Equal(t, sub.Batch{Texts: []string{"two"}, After: []string{"four"}}, provider.batches[1])
+}
+
+func TestMissingTranslatorAllMissing(t *testing.T) {
+	provider := &fakeTranslator{contents: []string{
+		`{"translations":[{"id":1,"text":"eins"},{"id":3,"text":"drei"}]}`,
+		`{"translations":[]}`,
+	}}
+	tr := newMissingTranslator(provider)
+
+	_, err := tr.Translate(t.Context(), sub.Batch{Texts: []string{"one", "two", "three"}})
+	assert.ErrorIs(t, err, sub.ErrCountMismatch)
+	assert.Len(t, provider.batches, 2)
+}
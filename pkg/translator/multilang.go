@@ -0,0 +1,192 @@
+package translator
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+)
+
+// langSeparator separates the language from the text in the batches of multi-language requests.
+const langSeparator = "\x1f"
+
+func withLang(lang, text string) string {
+	return lang + langSeparator + text
+}
+
+// splitLang returns the language and the source text of a text of a multi-language request.
+func splitLang(text string) (lang, source string, ok bool) {
+	return strings.Cut(text, langSeparator)
+}
+
+func hasLang(text string) bool {
+	return strings.Contains(text, langSeparator)
+}
+
+// MultiLangTranslator translates every batch to all its languages in one request. The translators of the languages
+// share the responses, so a batch translated to one language is translated to the others without another request.
+type MultiLangTranslator struct {
+	// translator translates batches whose texts are prefixed with their language
+	translator sub.Translator
+	langs      []string
+
+	mu sync.Mutex
+	// results maps the key of a batch to the translations of the languages that have not taken them yet
+	results map[string]*multiLangResult
+}
+
+// multiLangResult is the response to a batch, kept for the languages that have not taken their translations.
+type multiLangResult struct {
+	// producer is the provider that translated the batch, recorded for the languages' caches
+	producer     string
+	translations map[string][]string
+}
+
+// NewMultiLangTranslator returns the translator of llmProvider translating to langs in one request.
+func NewMultiLangTranslator(cfg *config.Config, promptKey, llmProvider string, langs []string, dryRun bool, recorder *Recorder) (*MultiLangTranslator, error) {
+	multiCfg := *cfg
+	multiCfg.TargetLang = strings.Join(langs, ", ")
+	t, err := NewLLMTranslator(&multiCfg, promptKey, llmProvider, dryRun, recorder)
+	if err != nil {
+		return nil, err
+	}
+	return newMultiLangTranslator(t, langs), nil
+}
+
+func newMultiLangTranslator(t sub.Translator, langs []string) *MultiLangTranslator {
+	return &MultiLangTranslator{
+		translator: t,
+		langs:      langs,
+		results:    map[string]*multiLangResult{},
+	}
+}
+
+// Translator returns the translator of the multi-language chain, e.g. to read its fallback summary.
+func (m *MultiLangTranslator) Translator() sub.Translator {
+	return m.translator
+}
+
+// Lang returns the translator of lang, one of the languages of m.
+func (m *MultiLangTranslator) Lang(lang string) sub.Translator {
+	return &langTranslator{m: m, lang: lang}
+}
+
+// translate returns the translations of the texts of batch to lang, requesting them to every language unless the
+// same batch was translated before. The translations of the other languages are kept until they take them.
+func (m *MultiLangTranslator) translate(ctx context.Context, batch sub.Batch, lang string) ([]string, error) {
+	key := batchKey(batch)
+	if translations, producer, ok := m.take(key, lang); ok {
+		setProducer(ctx, producer)
+		return translations, nil
+	}
+
+	request := sub.Batch{Before: batch.Before, After: batch.After}
+	for _, lang := range m.langs {
+		for _, text := range batch.Texts {
+			request.Texts = append(request.Texts, withLang(lang, text))
+		}
+	}
+	if info, ok := sub.BatchInfoFrom(ctx); ok {
+		// the request is made for all languages
+		info.TargetLang = strings.Join(m.langs, ", ")
+		ctx = sub.WithBatchInfo(ctx, info)
+	}
+	var producer string
+	translations, err := m.translator.Translate(withProducer(ctx, &producer), request)
+	if err != nil {
+		return nil, err
+	}
+	if len(translations) != len(request.Texts) {
+		return nil, fmt.Errorf("%w: got %d translations for %d input texts", sub.ErrCountMismatch, len(translations), len(request.Texts))
+	}
+
+	setProducer(ctx, producer)
+	result := &multiLangResult{producer: producer, translations: map[string][]string{}}
+	for i, l := range m.langs {
+		if l != lang {
+			result.translations[l] = translations[i*len(batch.Texts) : (i+1)*len(batch.Texts)]
+		}
+	}
+	m.mu.Lock()
+	m.results[key] = result
+	m.mu.Unlock()
+	i := slices.Index(m.langs, lang)
+	return translations[i*len(batch.Texts) : (i+1)*len(batch.Texts)], nil
+}
+
+// take removes the translations of the batch of key to lang from the results and returns them with their producer,
+// the batch is forgotten once every language has taken its translations.
+func (m *MultiLangTranslator) take(key, lang string) ([]string, string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, ok := m.results[key]
+	if !ok {
+		return nil, "", false
+	}
+	translations, ok := result.translations[lang]
+	if !ok {
+		return nil, "", false
+	}
+	delete(result.translations, lang)
+	if len(result.translations) == 0 {
+		delete(m.results, key)
+	}
+	return translations, result.producer, true
+}
+
+// batchKey identifies a batch by its texts and source context lines, the translations of the context lines differ
+// between the languages.
+func batchKey(batch sub.Batch) string {
+	parts := slices.Clone(batch.Texts)
+	for _, line := range batch.Before {
+		parts = append(parts, line.Text)
+	}
+	parts = append(parts, batch.After...)
+	return fmt.Sprintf("%d:%d:%s", len(batch.Texts), len(batch.Before), strings.Join(parts, "\x00"))
+}
+
+// langTranslator is the translator of one language of a MultiLangTranslator. The lengths of a text cover all
+// languages, as every request carries it once per language.
+type langTranslator struct {
+	m    *MultiLangTranslator
+	lang string
+}
+
+func (t *langTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	if len(batch.Texts) == 0 {
+		return []string{}, nil
+	}
+	translations, err := t.m.translate(ctx, batch, t.lang)
+	if err != nil {
+		return batch.Texts, err
+	}
+	return translations, nil
+}
+
+func (t *langTranslator) Length(text string) int {
+	length := 0
+	for _, lang := range t.m.langs {
+		length += t.m.translator.Length(withLang(lang, text))
+	}
+	return length
+}
+
+func (t *langTranslator) MaxLength() int {
+	return t.m.translator.MaxLength()
+}
+
+func (t *langTranslator) OutputLength(text string) int {
+	length := 0
+	for _, lang := range t.m.langs {
+		length += t.m.translator.OutputLength(withLang(lang, text))
+	}
+	return length
+}
+
+func (t *langTranslator) MaxOutputLength() int {
+	return t.m.translator.MaxOutputLength()
+}
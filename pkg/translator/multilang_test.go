@@ -0,0 +1,81 @@
+package translator
+
+import (
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToPromptMultiLang(t *testing.T) {
+	batch := sub.Batch{Texts: []string{withLang("German", "Hello"), withLang("French", "Hello")}}
+	prompt, err := toPrompt(defaultPromptTmpl, "German, French", testGlossary, batch)
+	require.NoError(t, err)
+	assert.Contains(t, prompt, "to German, French line by line")
+	assert.Contains(t, prompt, `[{"id":1,"lang":"German","text":"Hello"},{"id":2,"lang":"French","text":"Hello"}]`)
+	assert.Contains(t, prompt, multiLangNote)
+
+	prompt, err = toPrompt(defaultPromptTmpl, "German", testGlossary, sub.Batch{Texts: []string{"Hello"}})
+	require.NoError(t, err)
+	assert.NotContains(t, prompt, multiLangNote)
+}
+
+func TestMultiLangTranslator(t *testing.T) {
+	provider := &fakeTranslator{contents: []string{
+		`{"translations":[{"id":1,"text":"Hallo"},{"id":2,"text":"Tschüss"},{"id":3,"text":"Bonjour"},{"id":4,"text":"Au revoir"}]}`,
+	}}
+	ft := newFallbackTranslator([]string{"claude"}, []sub.Translator{newMissingTranslator(provider)})
+	m := newMultiLangTranslator(ft, []string{"German", "French"})
+
+	batch := sub.Batch{Texts: []string{"Hello", "Bye"}, After: []string{"See you"}}
+	german, err := m.Lang("German").Translate(t.Context(), batch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hallo", "Tschüss"}, german)
+
+	// the French translations came with the German request, from the same provider
+	var producer string
+	french, err := m.Lang("French").Translate(withProducer(t.Context(), &producer), batch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Bonjour", "Au revoir"}, french)
+	assert.Equal(t, "claude", producer)
+	assert.Empty(t, m.results, "the batch is forgotten once every language took it")
+
+	require.Len(t, provider.batches, 1)
+	assert.Equal(t, sub.Batch{
+		Texts: []string{withLang("German", "Hello"), withLang("German", "Bye"), withLang("French", "Hello"), withLang("French", "Bye")},
+		After: []string{"See you"},
+	}, provider.batches[0])
+}
+
+func TestBatchKey(t *testing.T) {
+	batch := sub.Batch{Texts: []string{"Hello"}, Before: []sub.ContextLine{{Text: "Hi", Translation: "Hallo"}}}
+	assert.Equal(t, batchKey(batch), batchKey(sub.Batch{Texts: []string{"Hello"}, Before: []sub.ContextLine{{Text: "Hi", Translation: "Salut"}}}))
+	assert.NotEqual(t, batchKey(batch), batchKey(sub.Batch{Texts: []string{"Hello"}, After: []string{"Hi"}}))
+	assert.NotEqual(t, batchKey(batch), batchKey(sub.Batch{Texts: []string{"Hello", "Hi"}}))
+}
+
+func TestMultiLangTranslatorFails(t *testing.T) {
+	provider := &fakeTranslator{contents: []string{`not JSON`, `{"translations":[{"id":1,"text":"Hallo"},{"id":2,"text":"Bonjour"}]}`}}
+	m := newMultiLangTranslator(provider, []string{"German", "French"})
+
+	batch := sub.Batch{Texts: []string{"Hello"}}
+	_, err := m.Lang("German").Translate(t.Context(), batch)
+	assert.ErrorIs(t, err, sub.ErrMalformedResponse)
+
+	// a failed request is not kept, the next language requests the batch again
+	french, err := m.Lang("French").Translate(t.Context(), batch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Bonjour"}, french)
+	assert.Len(t, provider.batches, 2)
+}
+
+func TestMultiLangTranslatorLength(t *testing.T) {
+	m := newMultiLangTranslator(&fakeTranslator{}, []string{"de", "fr"})
+	tr := m.Lang("de")
+	// fakeTranslator counts characters, every language adds its prefix and the text
+	assert.Equal(t, 2*len(withLang("de", "Hello")), tr.Length("Hello"))
+	assert.Equal(t, 2*len(withLang("de", "Hello")), tr.OutputLength("Hello"))
+	assert.Equal(t, 100, tr.MaxLength())
+	assert.Equal(t, 0, tr.MaxOutputLength())
+}
@@ -0,0 +1,188 @@
+package translator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+)
+
+const (
+	defaultOllamaURL = "http://localhost:11434"
+	// defaultOllamaContext is used when max_tokens is not set and the server does not report the context length
+	defaultOllamaContext = 8192
+	ollamaShowTimeout    = 10 * time.Second
+)
+
+// OllamaTranslator uses the native chat endpoint of a local Ollama server, so subtitles never leave the machine.
+type OllamaTranslator struct {
+	budget
+	Config     *config.Config
+	Provider   config.LLMProvider
+	client     *http.Client
+	baseURL    string
+	promptTmpl string
+	dryRun     bool
+}
+
+// ollamaError is a non-2xx response of the Ollama server.
+type ollamaError struct {
+	StatusCode int
+	Message    string
+	Header     http.Header
+}
+
+func (e *ollamaError) Error() string {
+	return fmt.Sprintf("ollama returned status %d: %s", e.StatusCode, e.Message)
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   any             `json:"format,omitempty"`
+	Options  map[string]any  `json:"options,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int64         `json:"prompt_eval_count"`
+	EvalCount       int64         `json:"eval_count"`
+}
+
+type ollamaShowResponse struct {
+	ModelInfo map[string]any `json:"model_info"`
+}
+
+func newOllamaTranslator(cfg *config.Config, provider config.LLMProvider, promptKey string, dryRun bool) (*OllamaTranslator, error) {
+	promptTmpl, err := PromptTemplate(cfg, promptKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prompt template: %w", err)
+	}
+
+	baseURL := provider.APIURL
+	if baseURL == "" {
+		baseURL = defaultOllamaURL
+	}
+
+	t := &OllamaTranslator{
+		Config:     cfg,
+		Provider:   provider,
+		client:     &http.Client{},
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		promptTmpl: promptTmpl,
+		dryRun:     dryRun,
+	}
+
+	if t.Provider.MaxTokens == 0 && dryRun {
+		// a dry run needs no server
+		t.Provider.MaxTokens = defaultOllamaContext
+	}
+	if t.Provider.MaxTokens == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), ollamaShowTimeout)
+		defer cancel()
+		contextLength, err := t.ContextLength(ctx)
+		if err != nil || contextLength == 0 {
+			log.Printf("Warning: failed to get the context length of %s, using %d: %v", provider.Model, defaultOllamaContext, err)
+			contextLength = defaultOllamaContext
+		}
+		t.Provider.MaxTokens = contextLength
+	}
+	t.budget = newBudget(cfg, t.Provider, promptTmpl)
+	return t, nil
+}
+
+// ContextLength asks the server for the context length of the model, it is 0 if the model does not report one.
+func (t *OllamaTranslator) ContextLength(ctx context.Context) (int, error) {
+	var resp ollamaShowResponse
+	if err := t.post(ctx, "/api/show", map[string]string{"model": t.Provider.Model}, &resp); err != nil {
+		return 0, err
+	}
+	// the key is prefixed with the model architecture, e.g. "llama.context_length"
+	for key, value := range resp.ModelInfo {
+		if n, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			return int(n), nil
+		}
+	}
+	return 0, nil
+}
+
+func (t *OllamaTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	texts := batch.Texts
+	if t.dryRun {
+		return make([]string, len(texts)), nil
+	}
+	if len(texts) == 0 {
+		return []string{}, nil
+	}
+
+	prompt, err := toPrompt(t.promptTmpl, t.Config.TargetLang, t.Config.Glossary, batch)
+	if err != nil {
+		return texts, err
+	}
+
+	// Ollama truncates prompts longer than num_ctx, which defaults to a few thousand tokens
+	options := map[string]any{"num_ctx": t.Provider.MaxTokens}
+	if t.Provider.MaxOutputTokens > 0 {
+		options["num_predict"] = t.Provider.MaxOutputTokens
+	}
+	var resp ollamaChatResponse
+	err = t.post(ctx, "/api/chat", ollamaChatRequest{
+		Model:    t.Provider.Model,
+		Messages: []ollamaMessage{{Role: "user", Content: prompt}},
+		Format:   translationResponseJSONSchema,
+		Options:  options,
+	}, &resp)
+	if err != nil {
+		return texts, fmt.Errorf("failed to get chat response from Ollama: %w", err)
+	}
+	reportUsage(ctx, Usage{PromptTokens: resp.PromptEvalCount, CompletionTokens: resp.EvalCount})
+	if resp.Message.Content == "" {
+		return texts, fmt.Errorf("empty response from Ollama")
+	}
+
+	return parseTranslationResponse(resp.Message.Content, texts)
+}
+
+// post sends body as JSON to path and decodes the JSON response into out.
+func (t *OllamaTranslator) post(ctx context.Context, path string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.Provider.APIKey != "" {
+		// a local server needs no key, one in front of a proxy may
+		req.Header.Set("Authorization", "Bearer "+t.Provider.APIKey)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &ollamaError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message)), Header: resp.Header}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
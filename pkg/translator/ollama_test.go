@@ -0,0 +1,104 @@
+package translator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOllamaTranslator(t *testing.T) {
+	var chatRequest map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/api/show":
+			var req map[string]string
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, "llama3", req["model"])
+			json.NewEncoder(w).Encode(map[string]any{
+				"model_info": map[string]any{"general.architecture": "llama", "llama.context_length": 32768},
+			})
+		case "/api/chat":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&chatRequest))
+			json.NewEncoder(w).Encode(map[string]any{
+				"message":           map[string]any{"role": "assistant", "content": `{"translations":["Hola","Adiós"]}`},
+				"done":              true,
+				"prompt_eval_count": 42,
+				"eval_count":        12,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{TargetLang: "Spanish"}
+	provider := config.LLMProvider{API: config.Ollama, APIURL: server.URL + "/", Model: "llama3"}
+	tr, err := newOllamaTranslator(cfg, provider, "default", false)
+	require.NoError(t, err)
+	assert.Equal(t, 32768, tr.Provider.MaxTokens)
+
+	recorder := NewRecorder(nil)
+	got, err := newUsageTranslator(tr, "local", "llama3", recorder).Translate(t.Context(), sub.Batch{Texts: []string{"Hello", "Bye"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hola", "Adiós"}, got)
+	assert.Equal(t, Usage{PromptTokens: 42, CompletionTokens: 12}, recorder.Report().Usage)
+
+	assert.Equal(t, "llama3", chatRequest["model"])
+	assert.Equal(t, false, chatRequest["stream"])
+	assert.Equal(t, map[string]any{"num_ctx": float64(32768)}, chatRequest["options"])
+	format := chatRequest["format"].(map[string]any)
+	assert.Equal(t, "object", format["type"])
+	assert.Contains(t, format["properties"], "translations")
+	messages := chatRequest["messages"].([]any)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].(map[string]any)["content"], `[{"id":1,"text":"Hello"},{"id":2,"text":"Bye"}]`)
+}
+
+func TestOllamaTranslatorDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{TargetLang: "Spanish"}
+	provider := config.LLMProvider{API: config.Ollama, APIURL: server.URL, Model: "llama3"}
+	tr, err := newOllamaTranslator(cfg, provider, "default", true)
+	require.NoError(t, err)
+	assert.Equal(t, defaultOllamaContext, tr.Provider.MaxTokens)
+
+	got, err := tr.Translate(t.Context(), sub.Batch{Texts: []string{"Hello"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{""}, got)
+}
+
+func TestOllamaTranslatorErrors(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer proxy-key", r.Header.Get("Authorization"))
+		http.Error(w, "model is loading", status)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{TargetLang: "Spanish"}
+	provider := config.LLMProvider{API: config.Ollama, APIKey: "proxy-key", APIURL: server.URL, Model: "llama3"}
+	tr, err := newOllamaTranslator(cfg, provider, "default", false)
+	require.NoError(t, err)
+	// the context length could not be queried
+	assert.Equal(t, defaultOllamaContext, tr.Provider.MaxTokens)
+
+	_, err = tr.Translate(t.Context(), sub.Batch{Texts: []string{"Hello"}})
+	assert.EqualError(t, err, "failed to get chat response from Ollama: ollama returned status 503: model is loading")
+	assert.True(t, isRetryable(err))
+
+	status = http.StatusNotFound
+	_, err = tr.Translate(t.Context(), sub.Batch{Texts: []string{"Hello"}})
+	require.Error(t, err)
+	assert.False(t, isRetryable(err))
+}
@@ -2,16 +2,19 @@ package translator
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/shared"
 )
 
 type OpenAICompactibleTranslator struct {
+	budget
 	Config     *config.Config
 	Provider   config.LLMProvider
 	client     openai.Client
@@ -20,21 +23,13 @@ type OpenAICompactibleTranslator struct {
 }
 
 func newOpenAITranslator(cfg *config.Config, provider config.LLMProvider, promptKey string, dryRun bool) *OpenAICompactibleTranslator {
-	promptTmpl, err := getPromptTmpl(cfg, promptKey)
+	promptTmpl, err := PromptTemplate(cfg, promptKey)
 	if err != nil {
 		// Since this function returns a non-error value, we'll use the default template
 		promptTmpl = defaultPromptTmpl
 	}
 
-	apiURL := provider.APIURL
-	if apiURL == "" {
-		apiURL = "https://api.openai.com/v1/"
-	}
-
-	client := openai.NewClient(
-		option.WithAPIKey(provider.APIKey),
-		option.WithBaseURL(apiURL),
-	)
+	client := openai.NewClient(openAIClientOptions(provider)...)
 
 	return &OpenAICompactibleTranslator{
 		Config:     cfg,
@@ -42,18 +37,36 @@ func newOpenAITranslator(cfg *config.Config, provider config.LLMProvider, prompt
 		client:     client,
 		promptTmpl: promptTmpl,
 		dryRun:     dryRun,
+		budget:     newBudget(cfg, provider, promptTmpl),
 	}
 }
 
-func (t *OpenAICompactibleTranslator) Length(text string) int {
-	return tokenCount(text)
-}
+func openAIClientOptions(provider config.LLMProvider) []option.RequestOption {
+	// requests are retried by retryTranslator
+	opts := []option.RequestOption{option.WithMaxRetries(0)}
+	if azure := provider.Azure; azure != nil {
+		// Azure routes by deployment and api-version and takes the key in its own header
+		baseURL := strings.TrimSuffix(azure.Endpoint, "/") + "/openai/deployments/" + url.PathEscape(azure.Deployment) + "/"
+		return append(opts,
+			option.WithBaseURL(baseURL),
+			option.WithQuery("api-version", azure.APIVersion),
+			option.WithHeaderDel("authorization"),
+			option.WithHeader("api-key", provider.APIKey),
+		)
+	}
 
-func (t *OpenAICompactibleTranslator) MaxLength() int {
-	return int(float64(t.Provider.MaxTokens) * 0.95)
+	apiURL := provider.APIURL
+	if apiURL == "" {
+		apiURL = "https://api.openai.com/v1/"
+	}
+	return append(opts,
+		option.WithAPIKey(provider.APIKey),
+		option.WithBaseURL(apiURL),
+	)
 }
 
-func (t *OpenAICompactibleTranslator) Translate(texts []string) ([]string, error) {
+func (t *OpenAICompactibleTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	texts := batch.Texts
 	if t.dryRun {
 		return make([]string, len(texts)), nil
 	}
@@ -61,19 +74,41 @@ func (t *OpenAICompactibleTranslator) Translate(texts []string) ([]string, error
 		return []string{}, nil
 	}
 
-	prompt, err := toPrompt(t.promptTmpl, t.Config.TargetLang, texts)
+	prompt, err := toPrompt(t.promptTmpl, t.Config.TargetLang, t.Config.Glossary, batch)
 	if err != nil {
 		return texts, err
 	}
 
-	ctx := context.Background()
-
-	responseFormat := openai.ChatCompletionNewParamsResponseFormatUnion{}
-	if t.Provider.StructureOutput == config.OpenAIJSONObject {
+	params := openai.ChatCompletionNewParams{
+		Model: shared.ChatModel(t.Provider.Model),
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.UserMessage(prompt),
+		},
+	}
+	switch t.Provider.StructureOutput {
+	case config.OpenAIJSONObject:
 		param := shared.NewResponseFormatJSONObjectParam()
-		responseFormat.OfJSONObject = &param
-	} else {
-		responseFormat.OfJSONSchema = &shared.ResponseFormatJSONSchemaParam{
+		params.ResponseFormat.OfJSONObject = &param
+	case config.OpenAIToolCall:
+		// for servers supporting tools but no response formats, forcing the tool makes the response structured
+		params.Tools = []openai.ChatCompletionToolParam{{
+			Function: shared.FunctionDefinitionParam{
+				Name:        submitTranslationsTool,
+				Description: openai.String(submitTranslationsDescription),
+				Parameters: shared.FunctionParameters{
+					"type":       "object",
+					"properties": translationResponseJSONSchema.Properties,
+					"required":   translationResponseJSONSchema.Required,
+				},
+			},
+		}}
+		params.ToolChoice.OfChatCompletionNamedToolChoice = &openai.ChatCompletionNamedToolChoiceParam{
+			Function: openai.ChatCompletionNamedToolChoiceFunctionParam{Name: submitTranslationsTool},
+		}
+	case config.OpenAINone:
+		// the JSON is parsed from the text of the response
+	default:
+		params.ResponseFormat.OfJSONSchema = &shared.ResponseFormatJSONSchemaParam{
 			JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
 				Name:   "translation_response",
 				Schema: translationResponseJSONSchema,
@@ -81,33 +116,37 @@ func (t *OpenAICompactibleTranslator) Translate(texts []string) ([]string, error
 			Type: "json_schema",
 		}
 	}
-
-	completion, err := t.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Model: shared.ChatModel(t.Provider.Model),
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(prompt),
-		},
-		ResponseFormat: responseFormat,
-	})
+	if t.Provider.MaxOutputTokens > 0 {
+		params.MaxCompletionTokens = openai.Int(int64(t.Provider.MaxOutputTokens))
+	}
+	completion, err := t.client.Chat.Completions.New(ctx, params)
 
 	if err != nil {
 		return texts, fmt.Errorf("failed to get completion from OpenAI API: %w", err)
 	}
+	reportUsage(ctx, Usage{
+		PromptTokens:     completion.Usage.PromptTokens,
+		CompletionTokens: completion.Usage.CompletionTokens,
+		CachedTokens:     completion.Usage.PromptTokensDetails.CachedTokens,
+		ReasoningTokens:  completion.Usage.CompletionTokensDetails.ReasoningTokens,
+	})
 
 	if len(completion.Choices) == 0 {
 		return texts, fmt.Errorf("no completion choices returned from OpenAI API")
 	}
 
-	content := completion.Choices[0].Message.Content
-
-	var result TranslationResponse
-	if err := json.Unmarshal([]byte(content), &result); err != nil {
-		return texts, fmt.Errorf("failed to unmarshal translation response: %w", err)
-	}
-
-	if len(result.Translations) == len(texts) {
-		return result.Translations, nil
+	message := completion.Choices[0].Message
+	switch t.Provider.StructureOutput {
+	case config.OpenAIToolCall:
+		for _, call := range message.ToolCalls {
+			if call.Function.Name == submitTranslationsTool {
+				return parseTranslationResponse(call.Function.Arguments, texts)
+			}
+		}
+		return texts, fmt.Errorf("%w: %w to %s in OpenAI response (finish reason %s)", sub.ErrMalformedResponse, errNoToolCall, submitTranslationsTool, completion.Choices[0].FinishReason)
+	case config.OpenAINone:
+		return parseTranslationResponse(extractJSON(message.Content), texts)
+	default:
+		return parseTranslationResponse(message.Content, texts)
 	}
-
-	return texts, fmt.Errorf("translation count mismatch: got %d translations for %d input texts", len(result.Translations), len(texts))
 }
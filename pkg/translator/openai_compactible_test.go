@@ -0,0 +1,168 @@
+package translator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newOpenAIStandIn serves chat completions, responding with message.
+func newOpenAIStandIn(t *testing.T, message map[string]any) (*httptest.Server, *map[string]any) {
+	var request map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":      "chatcmpl-1",
+			"object":  "chat.completion",
+			"model":   "gpt-test",
+			"choices": []any{map[string]any{"index": 0, "message": message, "finish_reason": "stop"}},
+			"usage": map[string]any{
+				"prompt_tokens":             20,
+				"completion_tokens":         8,
+				"total_tokens":              28,
+				"prompt_tokens_details":     map[string]any{"cached_tokens": 4},
+				"completion_tokens_details": map[string]any{"reasoning_tokens": 2},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &request
+}
+
+func TestOpenAIStructureOutput(t *testing.T) {
+	content := func(text string) map[string]any {
+		return map[string]any{"role": "assistant", "content": text}
+	}
+	toolCall := map[string]any{
+		"role":    "assistant",
+		"content": nil,
+		"tool_calls": []any{map[string]any{
+			"id":       "call_1",
+			"type":     "function",
+			"function": map[string]any{"name": submitTranslationsTool, "arguments": `{"translations":["Hola","Adiós"]}`},
+		}},
+	}
+
+	tests := []struct {
+		name            string
+		structureOutput string
+		message         map[string]any
+		check           func(t *testing.T, request map[string]any)
+	}{
+		{
+			name:            "json_schema",
+			structureOutput: config.OpenAIJSONSchema,
+			message:         content(`{"translations":["Hola","Adiós"]}`),
+			check: func(t *testing.T, request map[string]any) {
+				format := request["response_format"].(map[string]any)
+				assert.Equal(t, "json_schema", format["type"])
+				assert.NotContains(t, request, "tools")
+			},
+		},
+		{
+			name:            "json_object",
+			structureOutput: config.OpenAIJSONObject,
+			message:         content(`{"translations":["Hola","Adiós"]}`),
+			check: func(t *testing.T, request map[string]any) {
+				assert.Equal(t, map[string]any{"type": "json_object"}, request["response_format"])
+			},
+		},
+		{
+			name:            "tool_call",
+			structureOutput: config.OpenAIToolCall,
+			message:         toolCall,
+			check: func(t *testing.T, request map[string]any) {
+				assert.NotContains(t, request, "response_format")
+				assert.Equal(t, map[string]any{"type": "function", "function": map[string]any{"name": submitTranslationsTool}}, request["tool_choice"])
+				tools := request["tools"].([]any)
+				require.Len(t, tools, 1)
+				function := tools[0].(map[string]any)["function"].(map[string]any)
+				assert.Equal(t, submitTranslationsTool, function["name"])
+				parameters := function["parameters"].(map[string]any)
+				assert.Equal(t, "object", parameters["type"])
+				assert.Equal(t, []any{"translations"}, parameters["required"])
+				assert.Contains(t, parameters["properties"], "translations")
+			},
+		},
+		{
+			name:            "none",
+			structureOutput: config.OpenAINone,
+			message:         content("Sure! Here are the translations:\n```json\n{\"translations\":[\"Hola\",\"Adiós\"]}\n```"),
+			check: func(t *testing.T, request map[string]any) {
+				assert.NotContains(t, request, "response_format")
+				assert.NotContains(t, request, "tools")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, request := newOpenAIStandIn(t, tt.message)
+			provider := config.LLMProvider{API: config.OpenAI, APIKey: "test-key", APIURL: server.URL, Model: "gpt-test", MaxTokens: 1000, StructureOutput: tt.structureOutput}
+			tr := newOpenAITranslator(&config.Config{TargetLang: "Spanish"}, provider, "default", false)
+
+			recorder := NewRecorder(nil)
+			got, err := newUsageTranslator(tr, "openai", "gpt-test", recorder).Translate(t.Context(), sub.Batch{Texts: []string{"Hello", "Bye"}})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"Hola", "Adiós"}, got)
+			assert.Equal(t, Usage{PromptTokens: 20, CompletionTokens: 8, CachedTokens: 4, ReasoningTokens: 2}, recorder.Report().Usage)
+			tt.check(t, *request)
+		})
+	}
+}
+
+func TestOpenAIToolCallMissing(t *testing.T) {
+	server, _ := newOpenAIStandIn(t, map[string]any{"role": "assistant", "content": "I cannot do that."})
+	provider := config.LLMProvider{API: config.OpenAI, APIKey: "test-key", APIURL: server.URL, Model: "gpt-test", MaxTokens: 1000, StructureOutput: config.OpenAIToolCall}
+	tr := newOpenAITranslator(&config.Config{TargetLang: "Spanish"}, provider, "default", false)
+
+	_, err := tr.Translate(t.Context(), sub.Batch{Texts: []string{"Hello"}})
+	assert.ErrorIs(t, err, sub.ErrMalformedResponse)
+}
+
+func TestOpenAIAzure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/openai/deployments/prod-gpt/chat/completions", r.URL.Path)
+		assert.Equal(t, "2024-10-21", r.URL.Query().Get("api-version"))
+		assert.Equal(t, "test-key", r.Header.Get("Api-Key"))
+		assert.Empty(t, r.Header.Get("Authorization"))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":      "chatcmpl-1",
+			"object":  "chat.completion",
+			"model":   "gpt-4o",
+			"choices": []any{map[string]any{"index": 0, "message": map[string]any{"role": "assistant", "content": `{"translations":[{"id":1,"text":"Hola"}]}`}, "finish_reason": "stop"}},
+		})
+	}))
+	defer server.Close()
+
+	provider := config.LLMProvider{
+		API:       config.OpenAI,
+		APIKey:    "test-key",
+		Model:     "gpt-4o",
+		MaxTokens: 1000,
+		Azure:     &config.Azure{Endpoint: server.URL + "/", Deployment: "prod-gpt", APIVersion: "2024-10-21"},
+	}
+	tr := newOpenAITranslator(&config.Config{TargetLang: "Spanish"}, provider, "default", false)
+
+	got, err := tr.Translate(t.Context(), sub.Batch{Texts: []string{"Hello"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hola"}, got)
+}
+
+func TestExtractJSON(t *testing.T) {
+	assert.Equal(t, `{"translations":["a"]}`, extractJSON(`{"translations":["a"]}`))
+	assert.Equal(t, `{"translations":["a"]}`, extractJSON("```json\n{\"translations\":[\"a\"]}\n```"))
+	assert.Equal(t, `{"translations":["{b}"]}`, extractJSON(`Here: {"translations":["{b}"]} Done.`))
+	assert.Equal(t, "no json", extractJSON("no json"))
+}
@@ -0,0 +1,155 @@
+package translator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charleshuang3/subtrans/pkg/sub"
+)
+
+// placeholderRetries is the number of times texts whose translations break their placeholders are requested again
+// before their markup is stripped.
+const placeholderRetries = 1
+
+var (
+	// markupRegexp matches the inline markup hidden behind placeholders: the segment tags of sub, tags of SRT and
+	// WebVTT such as <i>, <font color="..."> or <c.class>, WebVTT timestamps and ASS override blocks in SRT text.
+	markupRegexp = regexp.MustCompile(`</?[A-Za-z][^<>]*>|<\d{1,2}:\d{2}(?::\d{2})?[.,]\d{3}>|\{\\[^{}]*\}`)
+	// placeholderRegexp matches the placeholders markup is replaced with, ⟦N⟧ is the N-th markup of a text.
+	placeholderRegexp = regexp.MustCompile(`⟦(\d+)⟧`)
+	spacesRegexp      = regexp.MustCompile(`[ \t]{2,}`)
+)
+
+func hasPlaceholder(text string) bool {
+	return placeholderRegexp.MatchString(text)
+}
+
+// protect replaces the markup of text with placeholders and returns the replaced markup in the order of their
+// numbers. The separator of sentence cues is no markup, it is checked by the caller.
+func protect(text string) (string, []string) {
+	var markup []string
+	text = markupRegexp.ReplaceAllStringFunc(text, func(m string) string {
+		if m == sub.CueSeparator {
+			return m
+		}
+		markup = append(markup, m)
+		return fmt.Sprintf("⟦%d⟧", len(markup))
+	})
+	return text, markup
+}
+
+// restore replaces the placeholders of translation with markup, it fails unless every placeholder of markup is
+// found exactly once and no other placeholder is.
+func restore(translation string, markup []string) (string, bool) {
+	seen := make([]bool, len(markup))
+	for _, m := range placeholderRegexp.FindAllStringSubmatch(translation, -1) {
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n < 1 || n > len(markup) || seen[n-1] {
+			return translation, false
+		}
+		seen[n-1] = true
+	}
+	if slices.Contains(seen, false) {
+		return translation, false
+	}
+	return placeholderRegexp.ReplaceAllStringFunc(translation, func(m string) string {
+		n, _ := strconv.Atoi(m[len("⟦") : len(m)-len("⟧")])
+		return markup[n-1]
+	}), true
+}
+
+// strip removes the placeholders of translation, the markup is lost but the text is kept.
+func strip(translation string) string {
+	translation = placeholderRegexp.ReplaceAllString(translation, "")
+	return strings.TrimSpace(spacesRegexp.ReplaceAllString(translation, " "))
+}
+
+// protectAll replaces the markup of every text, context lines included so they look like the texts.
+func protectAll(batch sub.Batch) (sub.Batch, [][]string) {
+	request := sub.Batch{Texts: make([]string, len(batch.Texts))}
+	markup := make([][]string, len(batch.Texts))
+	for i, text := range batch.Texts {
+		request.Texts[i], markup[i] = protect(text)
+	}
+	for _, line := range batch.Before {
+		text, _ := protect(line.Text)
+		translation, _ := protect(line.Translation)
+		request.Before = append(request.Before, sub.ContextLine{Text: text, Translation: translation})
+	}
+	for _, line := range batch.After {
+		text, _ := protect(line)
+		request.After = append(request.After, text)
+	}
+	return request, markup
+}
+
+// placeholderTranslator hides the inline markup of texts from the model behind opaque placeholders and puts it
+// back into the translations. Texts whose translations drop, repeat or invent placeholders are requested again up
+// to placeholderRetries times, after that their placeholders are stripped so the translation is kept without markup.
+type placeholderTranslator struct {
+	sub.Translator
+}
+
+func newPlaceholderTranslator(t sub.Translator) *placeholderTranslator {
+	return &placeholderTranslator{Translator: t}
+}
+
+func (t *placeholderTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	request, markup := protectAll(batch)
+	if !slices.ContainsFunc(markup, func(m []string) bool { return len(m) > 0 }) {
+		return t.Translator.Translate(ctx, batch)
+	}
+
+	translations, err := t.Translator.Translate(ctx, request)
+	if err != nil {
+		return translations, err
+	}
+
+	for attempt := 1; attempt <= placeholderRetries; attempt++ {
+		broken := brokenPlaceholders(translations, markup)
+		if len(broken) == 0 {
+			break
+		}
+
+		log.Printf("Translations of %d lines break their markup placeholders, requesting them again (attempt %d/%d)", len(broken), attempt, placeholderRetries)
+		retry := sub.Batch{Before: request.Before, After: request.After}
+		for _, i := range broken {
+			retry.Texts = append(retry.Texts, request.Texts[i])
+		}
+		retranslations, err := t.Translator.Translate(ctx, retry)
+		if err != nil {
+			// the first translations are still usable without markup
+			log.Printf("Warning: failed to request lines with broken placeholders again: %v", err)
+			break
+		}
+		for j, i := range broken {
+			translations[i] = retranslations[j]
+		}
+	}
+
+	for i, translation := range translations {
+		restored, ok := restore(translation, markup[i])
+		if !ok {
+			log.Printf("Warning: translation breaks markup placeholders, stripping the markup: %q => %q", request.Texts[i], translation)
+			restored = strip(translation)
+		}
+		translations[i] = restored
+	}
+	return translations, nil
+}
+
+// brokenPlaceholders returns the indices of translations whose placeholders do not match their markup.
+func brokenPlaceholders(translations []string, markup [][]string) []int {
+	broken := []int{}
+	for i, translation := range translations {
+		if _, ok := restore(translation, markup[i]); !ok {
+			broken = append(broken, i)
+		}
+	}
+	return broken
+}
@@ -0,0 +1,103 @@
+package translator
+
+import (
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtect(t *testing.T) {
+	tests := []struct {
+		text       string
+		want       string
+		wantMarkup []string
+	}{
+		{"I <s2>really</s2> mean <s4/>it", "I ⟦1⟧really⟦2⟧ mean ⟦3⟧it", []string{"<s2>", "</s2>", "<s4/>"}},
+		{`<font color="#ff0000">Stop</font>`, "⟦1⟧Stop⟦2⟧", []string{`<font color="#ff0000">`, "</font>"}},
+		{"<v Bob><c.yellow>Hi</c> <00:00:01.500>there", "⟦1⟧⟦2⟧Hi⟦3⟧ ⟦4⟧there", []string{"<v Bob>", "<c.yellow>", "</c>", "<00:00:01.500>"}},
+		{`{\an8}<ruby>漢<rt>kan</rt></ruby>`, "⟦1⟧⟦2⟧漢⟦3⟧kan⟦4⟧⟦5⟧", []string{`{\an8}`, "<ruby>", "<rt>", "</rt>", "</ruby>"}},
+		{"I told him\n<cue/>\nthat 2 < 3", "I told him\n<cue/>\nthat 2 < 3", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, markup := protect(tt.text)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantMarkup, markup)
+
+			restored, ok := restore(got, markup)
+			assert.True(t, ok)
+			assert.Equal(t, tt.text, restored)
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	markup := []string{"<s1>", "</s1>"}
+	tests := []struct {
+		name        string
+		translation string
+		want        string
+		wantOK      bool
+	}{
+		{"moved", "Es ist ⟦1⟧wirklich⟦2⟧ so", "Es ist <s1>wirklich</s1> so", true},
+		{"dropped", "Es ist wirklich⟦2⟧ so", "Es ist wirklich⟦2⟧ so", false},
+		{"repeated", "⟦1⟧Es⟦2⟧ ist ⟦1⟧wirklich⟦2⟧", "⟦1⟧Es⟦2⟧ ist ⟦1⟧wirklich⟦2⟧", false},
+		{"invented", "⟦1⟧Es⟦2⟧ ist ⟦3⟧so", "⟦1⟧Es⟦2⟧ ist ⟦3⟧so", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := restore(tt.translation, markup)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	assert.Equal(t, "Es ist wirklich so", strip("Es ist ⟦1⟧ wirklich⟦2⟧ so ⟦3⟧"))
+}
+
+func TestPlaceholderTranslator(t *testing.T) {
+	provider := &fakeTranslator{contents: []string{
+		`{"translations":[{"id":1,"text":"Ich meine es ⟦1⟧wirklich"},{"id":2,"text":"⟦1⟧Halt⟦2⟧"}]}`,
+		`{"translations":[{"id":1,"text":"Ich meine es ⟦1⟧wirklich⟦2⟧"}]}`,
+	}}
+	tr := newPlaceholderTranslator(provider)
+
+	batch := sub.Batch{
+		Texts:  []string{"I <s2>really</s2> mean it", "<i>Stop</i>"},
+		Before: []sub.ContextLine{{Text: "<i>Hi</i>", Translation: "<i>Hallo</i>"}},
+	}
+	got, err := tr.Translate(t.Context(), batch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Ich meine es <s2>wirklich</s2>", "<i>Halt</i>"}, got)
+
+	require.Len(t, provider.batches, 2)
+	assert.Equal(t, []string{"I ⟦1⟧really⟦2⟧ mean it", "⟦1⟧Stop⟦2⟧"}, provider.batches[0].Texts)
+	assert.Equal(t, []sub.ContextLine{{Text: "⟦1⟧Hi⟦2⟧", Translation: "⟦1⟧Hallo⟦2⟧"}}, provider.batches[0].Before)
+	// only the broken line is requested again
+	assert.Equal(t, []string{"I ⟦1⟧really⟦2⟧ mean it"}, provider.batches[1].Texts)
+}
+
+func TestPlaceholderTranslatorStrips(t *testing.T) {
+	provider := &fakeTranslator{contents: []string{
+		`{"translations":[{"id":1,"text":"⟦1⟧Halt⟦1⟧"}]}`,
+		`{"translations":[{"id":1,"text":"Halt ⟦3⟧"}]}`,
+	}}
+	tr := newPlaceholderTranslator(provider)
+
+	got, err := tr.Translate(t.Context(), sub.Batch{Texts: []string{"<i>Stop</i>"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Halt"}, got, "the translation is kept without markup")
+	assert.Len(t, provider.batches, 2)
+}
+
+func TestPlaceholderTranslatorWithoutMarkup(t *testing.T) {
+	provider := &fakeTranslator{contents: []string{`{"translations":[{"id":1,"text":"Hallo"}]}`}}
+	tr := newPlaceholderTranslator(provider)
+
+	batch := sub.Batch{Texts: []string{"Hello"}, After: []string{"<i>Bye</i>"}}
+	got, err := tr.Translate(t.Context(), batch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hallo"}, got)
+	assert.Equal(t, batch, provider.batches[0], "a batch without markup is passed on as it is")
+}
@@ -0,0 +1,175 @@
+package translator
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/openai/openai-go"
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter enforces the requests and tokens per minute of a provider with token buckets holding one minute of
+// each, and pauses all requests of the provider when a response asks to retry after some time.
+type rateLimiter struct {
+	requests *rate.Limiter // nil without a limit
+	tokens   *rate.Limiter // nil without a limit
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	// rateLimiters are shared by every translator of a provider, so batches translated concurrently and files
+	// translated one after another in the same process draw from the same limits.
+	rateLimiters = map[string]*rateLimiter{}
+)
+
+func newRateLimiter(rpm, tpm int) *rateLimiter {
+	l := &rateLimiter{}
+	if rpm > 0 {
+		l.requests = rate.NewLimiter(rate.Limit(float64(rpm)/60), rpm)
+	}
+	if tpm > 0 {
+		l.tokens = rate.NewLimiter(rate.Limit(float64(tpm)/60), tpm)
+	}
+	return l
+}
+
+// sharedRateLimiter returns the limiter of the provider called name, creating it on first use.
+func sharedRateLimiter(name string, provider config.LLMProvider) *rateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	l, ok := rateLimiters[name]
+	if !ok {
+		l = newRateLimiter(provider.RPM, provider.TPM)
+		rateLimiters[name] = l
+	}
+	return l
+}
+
+// wait blocks until a request of tokens may be sent.
+func (l *rateLimiter) wait(ctx context.Context, tokens int) error {
+	l.mu.Lock()
+	pause := time.Until(l.pausedUntil)
+	l.mu.Unlock()
+	if pause > 0 {
+		select {
+		case <-time.After(pause):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if l.requests != nil {
+		if err := l.requests.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if l.tokens != nil {
+		// a request larger than a minute of tokens waits for a full bucket instead of failing
+		if err := l.tokens.WaitN(ctx, min(tokens, l.tokens.Burst())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pause holds back all requests for d.
+func (l *rateLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// rateLimitTranslator waits for the rate limiter of its provider before every request.
+type rateLimitTranslator struct {
+	sub.Translator
+	limiter *rateLimiter
+}
+
+func newRateLimitTranslator(t sub.Translator, limiter *rateLimiter) *rateLimitTranslator {
+	return &rateLimitTranslator{
+		Translator: t,
+		limiter:    limiter,
+	}
+}
+
+func (t *rateLimitTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	if err := t.limiter.wait(ctx, t.batchTokens(batch)); err != nil {
+		return nil, err
+	}
+	translations, err := t.Translator.Translate(ctx, batch)
+	if d := retryAfter(err); d > 0 {
+		log.Printf("Provider asked to retry after %s, pausing its requests", d)
+		t.limiter.pause(d)
+	}
+	return translations, err
+}
+
+// batchTokens estimates the tokens a batch counts against the tpm limit: its texts, context lines and expected
+// translations.
+func (t *rateLimitTranslator) batchTokens(batch sub.Batch) int {
+	tokens := 0
+	for _, text := range batch.Texts {
+		tokens += t.Length(text) + t.OutputLength(text)
+	}
+	for _, line := range batch.Before {
+		tokens += t.Length(line.Text) + t.Length(line.Translation)
+	}
+	for _, text := range batch.After {
+		tokens += t.Length(text)
+	}
+	return tokens
+}
+
+// retryAfter returns the delay a failed response asked for in its Retry-After header, 0 if there is none.
+func retryAfter(err error) time.Duration {
+	if err == nil {
+		return 0
+	}
+	var header http.Header
+
+	var openaiErr *openai.Error
+	var anthropicErr *anthropic.Error
+	var ollamaErr *ollamaError
+	switch {
+	case errors.As(err, &openaiErr) && openaiErr.Response != nil:
+		header = openaiErr.Response.Header
+	case errors.As(err, &anthropicErr) && anthropicErr.Response != nil:
+		header = anthropicErr.Response.Header
+	case errors.As(err, &ollamaErr):
+		header = ollamaErr.Header
+	default:
+		return 0
+	}
+	return parseRetryAfter(header, time.Now())
+}
+
+// parseRetryAfter parses the Retry-After header in seconds or as a date, and the retry-after-ms header of OpenAI.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return max(time.Duration(seconds*float64(time.Second)), 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,115 @@
+package translator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"none", http.Header{}, 0},
+		{"seconds", http.Header{"Retry-After": {"20"}}, 20 * time.Second},
+		{"milliseconds", http.Header{"Retry-After-Ms": {"1500"}, "Retry-After": {"2"}}, 1500 * time.Millisecond},
+		{"date", http.Header{"Retry-After": {"Wed, 01 Jan 2025 12:00:30 GMT"}}, 30 * time.Second},
+		{"past date", http.Header{"Retry-After": {"Wed, 01 Jan 2025 11:00:00 GMT"}}, 0},
+		{"invalid", http.Header{"Retry-After": {"soon"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseRetryAfter(tt.header, now))
+		})
+	}
+}
+
+func TestRateLimiterTokens(t *testing.T) {
+	// one token per second with a bucket of one minute
+	l := newRateLimiter(0, 60)
+	require.NoError(t, l.wait(t.Context(), 30))
+	// larger than the bucket, waits for a full bucket only
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, l.wait(ctx, 1000))
+	// the rest of the bucket is still there
+	require.NoError(t, l.wait(t.Context(), 30))
+
+	ctx, cancel = context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, l.wait(ctx, 5), "bucket is empty")
+}
+
+func TestRateLimiterRequests(t *testing.T) {
+	l := newRateLimiter(2, 0)
+	require.NoError(t, l.wait(t.Context(), 0))
+	require.NoError(t, l.wait(t.Context(), 0))
+
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, l.wait(ctx, 0), "third request within a minute")
+}
+
+func TestRateLimiterPause(t *testing.T) {
+	l := newRateLimiter(0, 0)
+	l.pause(50 * time.Millisecond)
+	// a shorter pause does not shorten the current one
+	l.pause(time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, l.wait(t.Context(), 0))
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+func TestSharedRateLimiter(t *testing.T) {
+	provider := config.LLMProvider{RPM: 10}
+	assert.Same(t, sharedRateLimiter("shared-test", provider), sharedRateLimiter("shared-test", provider))
+	assert.NotSame(t, sharedRateLimiter("shared-test", provider), sharedRateLimiter("shared-test-2", provider))
+}
+
+func TestRateLimitTranslatorRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "2")
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{TargetLang: "Spanish"}
+	provider := config.LLMProvider{API: config.Ollama, APIURL: server.URL, Model: "llama3", MaxTokens: 8192}
+	ollama, err := newOllamaTranslator(cfg, provider, "default", false)
+	require.NoError(t, err)
+	limiter := newRateLimiter(0, 0)
+	tr := newRateLimitTranslator(ollama, limiter)
+
+	_, err = tr.Translate(t.Context(), sub.Batch{Texts: []string{"Hello"}})
+	require.Error(t, err)
+	assert.Equal(t, 2*time.Second, retryAfter(err))
+	assert.WithinDuration(t, time.Now().Add(2*time.Second), limiter.pausedUntil, 500*time.Millisecond)
+
+	// the next request waits for the pause
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	_, err = tr.Translate(ctx, sub.Batch{Texts: []string{"Hello"}})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRateLimitTranslatorBatchTokens(t *testing.T) {
+	tr := newRateLimitTranslator(&fakeTranslator{}, newRateLimiter(0, 0))
+	batch := sub.Batch{
+		Texts:  []string{"abc", "de"},
+		Before: []sub.ContextLine{{Text: "f", Translation: "gh"}},
+		After:  []string{"ijkl"},
+	}
+	// fakeTranslator counts characters for both lengths
+	assert.Equal(t, 2*5+3+4, tr.batchTokens(batch))
+}
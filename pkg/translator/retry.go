@@ -0,0 +1,104 @@
+package translator
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/openai/openai-go"
+	"google.golang.org/genai"
+)
+
+// retryTranslator retries requests failing with a transient error, waiting with exponential backoff and jitter.
+type retryTranslator struct {
+	sub.Translator
+	retry config.Retry
+}
+
+func newRetryTranslator(t sub.Translator, retry config.Retry) *retryTranslator {
+	return &retryTranslator{
+		Translator: t,
+		retry:      retry,
+	}
+}
+
+func (t *retryTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	for attempt := 1; ; attempt++ {
+		translations, err := t.translateOnce(ctx, batch)
+		if err == nil {
+			return translations, nil
+		}
+		if attempt >= t.retry.MaxAttempts || ctx.Err() != nil || !isRetryable(err) {
+			return translations, err
+		}
+
+		// a rate limited response may ask to wait longer than the backoff
+		delay := max(backoff(t.retry, attempt), retryAfter(err))
+		log.Printf("Request failed (attempt %d/%d), retrying in %s: %v", attempt, t.retry.MaxAttempts, delay.Round(time.Millisecond), err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return translations, err
+		}
+	}
+}
+
+func (t *retryTranslator) translateOnce(ctx context.Context, batch sub.Batch) ([]string, error) {
+	if t.retry.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.retry.Timeout)
+		defer cancel()
+	}
+	return t.Translator.Translate(ctx, batch)
+}
+
+// backoff returns the delay before retrying after attempt, a random value between half and all of
+// the exponential delay.
+func backoff(retry config.Retry, attempt int) time.Duration {
+	delay := retry.InitialDelay << (attempt - 1)
+	if delay <= 0 || delay > retry.MaxDelay {
+		delay = retry.MaxDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// isRetryable reports whether err is transient: rate limits, server errors and timeouts.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return isRetryableStatus(openaiErr.StatusCode)
+	}
+
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return isRetryableStatus(anthropicErr.StatusCode)
+	}
+
+	var ollamaErr *ollamaError
+	if errors.As(err, &ollamaErr) {
+		return isRetryableStatus(ollamaErr.StatusCode)
+	}
+
+	var geminiErr genai.APIError
+	if errors.As(err, &geminiErr) {
+		return isRetryableStatus(geminiErr.Code)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func isRetryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
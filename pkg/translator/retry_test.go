@@ -0,0 +1,148 @@
+package translator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genai"
+)
+
+// fakeTranslator echoes the texts of every batch unless a call is scripted to fail or respond otherwise.
+type fakeTranslator struct {
+	// errs fails the calls in order, nil entries succeed
+	errs  []error
+	calls int
+	// block waits for the request context to be done on these calls
+	block map[int]bool
+	// batches records every requested batch
+	batches []sub.Batch
+	// responses are returned by the calls in order
+	responses [][]string
+	// contents are parsed like the JSON response of a provider by the calls in order
+	contents []string
+}
+
+func (f *fakeTranslator) Length(text string) int { return len(text) }
+
+func (f *fakeTranslator) MaxLength() int { return 100 }
+
+func (f *fakeTranslator) OutputLength(text string) int { return len(text) }
+
+func (f *fakeTranslator) MaxOutputLength() int { return 0 }
+
+func (f *fakeTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	f.calls++
+	f.batches = append(f.batches, batch)
+	if f.block[f.calls] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if f.calls <= len(f.errs) && f.errs[f.calls-1] != nil {
+		return nil, f.errs[f.calls-1]
+	}
+	if f.calls <= len(f.responses) {
+		return f.responses[f.calls-1], nil
+	}
+	if f.calls <= len(f.contents) {
+		return parseTranslationResponse(f.contents[f.calls-1], batch.Texts)
+	}
+	return batch.Texts, nil
+}
+
+func TestRetryTranslator(t *testing.T) {
+	retry := config.Retry{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "success",
+			wantCalls: 1,
+		},
+		{
+			name:      "rate limited then success",
+			errs:      []error{&openai.Error{StatusCode: http.StatusTooManyRequests}},
+			wantCalls: 2,
+		},
+		{
+			name:      "server errors then success",
+			errs:      []error{genai.APIError{Code: 503}, fmt.Errorf("wrapped: %w", genai.APIError{Code: 500})},
+			wantCalls: 3,
+		},
+		{
+			name:      "attempts exhausted",
+			errs:      []error{genai.APIError{Code: 503}, genai.APIError{Code: 503}, genai.APIError{Code: 503}},
+			wantCalls: 3,
+			wantErr:   true,
+		},
+		{
+			name:      "bad request is not retried",
+			errs:      []error{&openai.Error{StatusCode: http.StatusBadRequest}},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "count mismatch is not retried",
+			errs:      []error{fmt.Errorf("%w: got 1 translations for 2 input texts", sub.ErrCountMismatch)},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &fakeTranslator{errs: tt.errs}
+			translator := newRetryTranslator(inner, retry)
+			got, err := translator.Translate(t.Context(), sub.Batch{Texts: []string{"a", "b"}})
+			assert.Equal(t, tt.wantCalls, inner.calls)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Equal(t, tt.errs[len(tt.errs)-1], err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, []string{"a", "b"}, got)
+			}
+		})
+	}
+}
+
+func TestRetryTranslatorTimeout(t *testing.T) {
+	retry := config.Retry{MaxAttempts: 2, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Timeout: 10 * time.Millisecond}
+	inner := &fakeTranslator{block: map[int]bool{1: true}}
+
+	got, err := newRetryTranslator(inner, retry).Translate(t.Context(), sub.Batch{Texts: []string{"a"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, got)
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestRetryTranslatorCancelled(t *testing.T) {
+	retry := config.Retry{MaxAttempts: 3, InitialDelay: time.Hour, MaxDelay: time.Hour}
+	inner := &fakeTranslator{errs: []error{genai.APIError{Code: 503}}}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	_, err := newRetryTranslator(inner, retry).Translate(ctx, sub.Batch{Texts: []string{"a"}})
+	require.Error(t, err)
+	assert.Equal(t, 1, inner.calls)
+}
+
+func TestBackoff(t *testing.T) {
+	retry := config.Retry{InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 100: 5 * time.Second} {
+		got := backoff(retry, attempt)
+		assert.GreaterOrEqual(t, got, want/2, "attempt %d", attempt)
+		assert.LessOrEqual(t, got, want, "attempt %d", attempt)
+	}
+}
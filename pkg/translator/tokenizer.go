@@ -0,0 +1,107 @@
+package translator
+
+import (
+	"log"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/tiktoken-go/tokenizer"
+)
+
+// defaultCharsPerToken is the number of Latin characters per token of the heuristic tokenizer.
+const defaultCharsPerToken = 4.0
+
+// tokenCounter estimates the number of tokens of a text, it is approximate: count(a)+count(b) != count(a+b).
+type tokenCounter func(text string) int
+
+// heuristicScripts are tokenized denser than Latin text, the multiplier scales the tokens per character.
+var heuristicScripts = []struct {
+	tables     []*unicode.RangeTable
+	multiplier float64
+}{
+	// ideographs and syllables are usually a token or more each
+	{[]*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul}, defaultCharsPerToken},
+	{[]*unicode.RangeTable{unicode.Cyrillic, unicode.Greek, unicode.Arabic, unicode.Hebrew, unicode.Devanagari, unicode.Thai}, 2},
+}
+
+func newTokenCounter(provider config.LLMProvider) tokenCounter {
+	var encoding tokenizer.Encoding
+	switch name := resolveTokenizer(provider); name {
+	case config.TokenizerHeuristic:
+		return heuristicCounter(provider.CharsPerToken)
+	case config.TokenizerO200k:
+		encoding = tokenizer.O200kBase
+	case config.TokenizerP50k:
+		encoding = tokenizer.P50kBase
+	default:
+		encoding = tokenizer.Cl100kBase
+	}
+
+	codec, err := tokenizer.Get(encoding)
+	if err != nil {
+		log.Printf("Warning: failed to load tokenizer %s, estimating tokens from characters: %v", encoding, err)
+		return heuristicCounter(provider.CharsPerToken)
+	}
+	return func(text string) int {
+		n, _ := codec.Count(text)
+		return n
+	}
+}
+
+// resolveTokenizer returns the tokenizer of provider, automatically chosen from the API and model if not set.
+func resolveTokenizer(provider config.LLMProvider) string {
+	if provider.Tokenizer != "" && provider.Tokenizer != config.TokenizerAuto {
+		return provider.Tokenizer
+	}
+
+	// OpenAI compatible routers prefix models with the vendor, e.g. "openai/gpt-4o"
+	model := strings.ToLower(provider.Model)
+	model = model[strings.LastIndex(model, "/")+1:]
+	hasPrefix := func(prefixes ...string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(model, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case provider.API == config.Gemini || provider.API == config.Anthropic || provider.API == config.Ollama:
+		return config.TokenizerHeuristic
+	case hasPrefix("gpt-4o", "chatgpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4", "gpt-oss"):
+		return config.TokenizerO200k
+	case hasPrefix("gpt-4", "gpt-3.5"):
+		return config.TokenizerCl100k
+	case hasPrefix("text-davinci", "code-"):
+		return config.TokenizerP50k
+	case hasPrefix("gemini", "gemma", "claude", "llama", "qwen", "mistral", "mixtral"):
+		return config.TokenizerHeuristic
+	default:
+		return config.TokenizerCl100k
+	}
+}
+
+// heuristicCounter estimates tokens from characters, charsPerToken Latin characters are one token and denser
+// scripts are scaled by their multiplier.
+func heuristicCounter(charsPerToken float64) tokenCounter {
+	if charsPerToken <= 0 {
+		charsPerToken = defaultCharsPerToken
+	}
+	return func(text string) int {
+		tokens := 0.0
+	runes:
+		for _, r := range text {
+			for _, script := range heuristicScripts {
+				if unicode.In(r, script.tables...) {
+					tokens += script.multiplier / charsPerToken
+					continue runes
+				}
+			}
+			tokens += 1 / charsPerToken
+		}
+		return int(math.Ceil(tokens))
+	}
+}
@@ -0,0 +1,53 @@
+package translator
+
+import (
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveTokenizer(t *testing.T) {
+	tests := []struct {
+		provider config.LLMProvider
+		want     string
+	}{
+		{config.LLMProvider{API: config.OpenAI, Model: "gpt-4o-mini"}, config.TokenizerO200k},
+		{config.LLMProvider{API: config.OpenAI, Model: "o3-mini"}, config.TokenizerO200k},
+		{config.LLMProvider{API: config.OpenAI, Model: "openai/gpt-5", Tokenizer: config.TokenizerAuto}, config.TokenizerO200k},
+		{config.LLMProvider{API: config.OpenAI, Model: "gpt-4-turbo"}, config.TokenizerCl100k},
+		{config.LLMProvider{API: config.OpenAI, Model: "text-davinci-003"}, config.TokenizerP50k},
+		{config.LLMProvider{API: config.OpenAI, Model: "deepseek-chat"}, config.TokenizerCl100k},
+		{config.LLMProvider{API: config.OpenAI, Model: "google/gemini-2.5-flash"}, config.TokenizerHeuristic},
+		{config.LLMProvider{API: config.Gemini, Model: "gemini-2.5-pro"}, config.TokenizerHeuristic},
+		{config.LLMProvider{API: config.Anthropic, Model: "claude-sonnet-4-5"}, config.TokenizerHeuristic},
+		{config.LLMProvider{API: config.Ollama, Model: "qwen2.5:14b"}, config.TokenizerHeuristic},
+		{config.LLMProvider{API: config.Gemini, Model: "gemini-2.5-pro", Tokenizer: config.TokenizerCl100k}, config.TokenizerCl100k},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider.Model, func(t *testing.T) {
+			assert.Equal(t, tt.want, resolveTokenizer(tt.provider))
+		})
+	}
+}
+
+func TestHeuristicCounter(t *testing.T) {
+	count := heuristicCounter(0)
+	assert.Equal(t, 0, count(""))
+	assert.Equal(t, 3, count("Hello world!"))
+	assert.Equal(t, 5, count("你好世界！"))
+	assert.Equal(t, 3, count("Привет"))
+
+	assert.Equal(t, 6, heuristicCounter(2)("Hello world!"))
+}
+
+func TestNewTokenCounter(t *testing.T) {
+	text := "The quick brown fox jumps over the lazy dog. 敏捷的棕色狐狸跳过了懒狗。"
+	cl100k := newTokenCounter(config.LLMProvider{Tokenizer: config.TokenizerCl100k})
+	o200k := newTokenCounter(config.LLMProvider{Tokenizer: config.TokenizerO200k})
+	p50k := newTokenCounter(config.LLMProvider{Tokenizer: config.TokenizerP50k})
+
+	assert.Equal(t, 10, cl100k("The quick brown fox jumps over the lazy dog."))
+	assert.Less(t, o200k(text), cl100k(text))
+	assert.Less(t, cl100k(text), p50k(text))
+}
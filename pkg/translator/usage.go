@@ -0,0 +1,183 @@
+package translator
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+)
+
+// Usage is the number of tokens of requests as reported by the API.
+type Usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"` // includes the reasoning tokens
+	CachedTokens     int64 `json:"cached_tokens"`     // part of the prompt tokens read from the prompt cache
+	ReasoningTokens  int64 `json:"reasoning_tokens"`
+}
+
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.CachedTokens += other.CachedTokens
+	u.ReasoningTokens += other.ReasoningTokens
+}
+
+// Cost returns the price of u in USD, cached prompt tokens are charged at the cached price when there is one.
+func (u Usage) Cost(pricing config.Pricing) float64 {
+	cachedPrice := pricing.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = pricing.Input
+	}
+	cost := float64(u.PromptTokens-u.CachedTokens)*pricing.Input +
+		float64(u.CachedTokens)*cachedPrice +
+		float64(u.CompletionTokens)*pricing.Output
+	return cost / 1e6
+}
+
+type usageKey struct{}
+
+// reportUsage adds the usage of a response to the request of ctx, it is a no-op outside a usageTranslator.
+func reportUsage(ctx context.Context, usage Usage) {
+	if u, ok := ctx.Value(usageKey{}).(*Usage); ok {
+		u.Add(usage)
+	}
+}
+
+// Request is one request sent to a provider. Input, TargetLang and Batch identify the batch it was made for, retries,
+// split halves and re-requests of missing lines of a batch share them.
+type Request struct {
+	Provider   string    `json:"provider"`
+	Model      string    `json:"model"`
+	Input      string    `json:"input,omitempty"`
+	TargetLang string    `json:"target_lang,omitempty"`
+	Batch      int       `json:"batch,omitempty"`
+	Texts      int       `json:"texts"`
+	Start      time.Time `json:"start"`
+	DurationMS int64     `json:"duration_ms"`
+	Usage
+	Cost  float64 `json:"cost"`
+	Error string  `json:"error,omitempty"`
+}
+
+// Report is the usage of a run, written as the JSON run report.
+type Report struct {
+	Input      string    `json:"input,omitempty"`
+	Output     string    `json:"output,omitempty"`
+	TargetLang string    `json:"target_lang,omitempty"`
+	Start      time.Time `json:"start"`
+	DurationMS int64     `json:"duration_ms"`
+	Usage
+	Cost     float64   `json:"cost"`
+	Requests []Request `json:"requests"`
+}
+
+// Recorder collects the requests of a run, it is safe for concurrent use.
+type Recorder struct {
+	pricing  map[string]config.Pricing
+	mu       sync.Mutex
+	requests []Request
+}
+
+// NewRecorder returns a recorder pricing requests with pricing, keyed by model.
+func NewRecorder(pricing map[string]config.Pricing) *Recorder {
+	return &Recorder{pricing: pricing}
+}
+
+func (r *Recorder) record(req Request) {
+	if pricing, ok := r.pricing[req.Model]; ok {
+		req.Cost = req.Usage.Cost(pricing)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+}
+
+// Report returns the requests recorded so far and their totals.
+func (r *Recorder) Report() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := Report{Requests: slices.Clone(r.requests)}
+	for _, req := range r.requests {
+		report.Usage.Add(req.Usage)
+		report.Cost += req.Cost
+	}
+	return report
+}
+
+// Summary returns the token usage and cost of all requests, per provider when there are several.
+func (r *Recorder) Summary() string {
+	report := r.Report()
+	providers := map[string]*Report{}
+	for _, req := range report.Requests {
+		p, ok := providers[req.Provider]
+		if !ok {
+			p = &Report{}
+			providers[req.Provider] = p
+		}
+		p.Usage.Add(req.Usage)
+		p.Cost += req.Cost
+		p.Requests = append(p.Requests, req)
+	}
+
+	lines := []string{"Usage: " + summaryLine(report)}
+	if len(providers) > 1 {
+		for _, name := range slices.Sorted(maps.Keys(providers)) {
+			lines = append(lines, fmt.Sprintf("  %s: %s", name, summaryLine(*providers[name])))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func summaryLine(r Report) string {
+	s := fmt.Sprintf("%d requests, %d prompt tokens (%d cached), %d completion tokens (%d reasoning)",
+		len(r.Requests), r.PromptTokens, r.CachedTokens, r.CompletionTokens, r.ReasoningTokens)
+	if r.Cost > 0 {
+		s += fmt.Sprintf(", $%.4f", r.Cost)
+	}
+	return s
+}
+
+// usageTranslator records the timing and token usage of every request of a provider.
+type usageTranslator struct {
+	sub.Translator
+	name     string
+	model    string
+	recorder *Recorder
+}
+
+func newUsageTranslator(t sub.Translator, name, model string, recorder *Recorder) *usageTranslator {
+	return &usageTranslator{
+		Translator: t,
+		name:       name,
+		model:      model,
+		recorder:   recorder,
+	}
+}
+
+func (t *usageTranslator) Translate(ctx context.Context, batch sub.Batch) ([]string, error) {
+	usage := &Usage{}
+	start := time.Now()
+	translations, err := t.Translator.Translate(context.WithValue(ctx, usageKey{}, usage), batch)
+	req := Request{
+		Provider:   t.name,
+		Model:      t.model,
+		Texts:      len(batch.Texts),
+		Start:      start,
+		DurationMS: time.Since(start).Milliseconds(),
+		Usage:      *usage,
+	}
+	if info, ok := sub.BatchInfoFrom(ctx); ok {
+		req.Input, req.TargetLang, req.Batch = info.Input, info.TargetLang, info.Batch
+	}
+	if err != nil {
+		req.Error = err.Error()
+	}
+	t.recorder.record(req)
+	return translations, err
+}
@@ -0,0 +1,69 @@
+package translator
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/charleshuang3/subtrans/pkg/config"
+	"github.com/charleshuang3/subtrans/pkg/sub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageCost(t *testing.T) {
+	usage := Usage{PromptTokens: 1_000_000, CachedTokens: 400_000, CompletionTokens: 200_000, ReasoningTokens: 50_000}
+	assert.InDelta(t, 0.6*2+0.4*0.5+0.2*8, usage.Cost(config.Pricing{Input: 2, CachedInput: 0.5, Output: 8}), 1e-9)
+	// without a cached price cached tokens cost as much as the others
+	assert.InDelta(t, 2+0.2*8, usage.Cost(config.Pricing{Input: 2, Output: 8}), 1e-9)
+}
+
+func TestUsageTranslator(t *testing.T) {
+	server, _ := newAnthropicStandIn(t, http.StatusOK, []map[string]any{
+		{"type": "tool_use", "id": "toolu_1", "name": submitTranslationsTool, "input": map[string]any{"translations": []string{"Hola", "Adiós"}}},
+	})
+	recorder := NewRecorder(map[string]config.Pricing{"claude-test": {Input: 3, Output: 15}})
+	tr := newUsageTranslator(newTestAnthropicTranslator(t, server.URL), "claude", "claude-test", recorder)
+
+	ctx := sub.WithBatchInfo(t.Context(), sub.BatchInfo{Input: "ep1.srt", TargetLang: "Spanish", Batch: 3})
+	_, err := tr.Translate(ctx, sub.Batch{Texts: []string{"Hello", "Bye"}})
+	require.NoError(t, err)
+
+	report := recorder.Report()
+	require.Len(t, report.Requests, 1)
+	req := report.Requests[0]
+	assert.Equal(t, "claude", req.Provider)
+	assert.Equal(t, "claude-test", req.Model)
+	assert.Equal(t, "ep1.srt", req.Input)
+	assert.Equal(t, "Spanish", req.TargetLang)
+	assert.Equal(t, 3, req.Batch)
+	assert.Equal(t, 2, req.Texts)
+	assert.Equal(t, Usage{PromptTokens: 10, CompletionTokens: 5}, req.Usage)
+	assert.InDelta(t, (10*3+5*15)/1e6, req.Cost, 1e-12)
+	assert.Equal(t, req.Usage, report.Usage)
+	assert.InDelta(t, req.Cost, report.Cost, 1e-12)
+}
+
+func TestUsageTranslatorRecordsFailures(t *testing.T) {
+	recorder := NewRecorder(nil)
+	tr := newUsageTranslator(&fakeTranslator{errs: []error{errors.New("boom")}}, "openai", "gpt-4o", recorder)
+
+	_, err := tr.Translate(t.Context(), sub.Batch{Texts: []string{"a"}})
+	require.Error(t, err)
+
+	report := recorder.Report()
+	require.Len(t, report.Requests, 1)
+	assert.Equal(t, "boom", report.Requests[0].Error)
+	assert.Zero(t, report.Cost)
+}
+
+func TestRecorderSummary(t *testing.T) {
+	recorder := NewRecorder(map[string]config.Pricing{"gpt-4o": {Input: 2.5, Output: 10}})
+	recorder.record(Request{Provider: "openai", Model: "gpt-4o", Usage: Usage{PromptTokens: 1000, CachedTokens: 200, CompletionTokens: 400, ReasoningTokens: 100}})
+	assert.Equal(t, "Usage: 1 requests, 1000 prompt tokens (200 cached), 400 completion tokens (100 reasoning), $0.0065", recorder.Summary())
+
+	recorder.record(Request{Provider: "local", Model: "llama3", Usage: Usage{PromptTokens: 500, CompletionTokens: 100}})
+	assert.Equal(t, `Usage: 2 requests, 1500 prompt tokens (200 cached), 500 completion tokens (100 reasoning), $0.0065
+  local: 1 requests, 500 prompt tokens (0 cached), 100 completion tokens (0 reasoning)
+  openai: 1 requests, 1000 prompt tokens (200 cached), 400 completion tokens (100 reasoning), $0.0065`, recorder.Summary())
+}